@@ -37,6 +37,16 @@ func (cw *CachedTemplateWriter) WriteTemplate(
 	file string,
 	data interface{},
 ) (bool, error) {
+	content, err := cw.RenderTemplate(data)
+	if err != nil {
+		return false, err
+	}
+
+	return cw.WriteContent(file, content)
+}
+
+// RenderTemplate executes the template without writing the result, so it can be checked before WriteContent
+func (cw *CachedTemplateWriter) RenderTemplate(data interface{}) ([]byte, error) {
 	buf := bytes.Buffer{}
 	err := func() error {
 		var bufWriter io.Writer
@@ -54,11 +64,15 @@ func (cw *CachedTemplateWriter) WriteTemplate(
 		return nil
 	}()
 	if err != nil {
-		return false, err
+		return nil, err
 	}
 
-	str := string(buf.Bytes())
-	hashStr := cw.hash(str)
+	return buf.Bytes(), nil
+}
+
+// WriteContent writes content rendered by RenderTemplate to the file, unless the file already has the same content
+func (cw *CachedTemplateWriter) WriteContent(file string, content []byte) (bool, error) {
+	hashStr := cw.hash(string(content))
 
 	existingFileStr, err := cw.getFileContent(file)
 	if err == nil {
@@ -84,7 +98,7 @@ func (cw *CachedTemplateWriter) WriteTemplate(
 		}
 	}(f)
 
-	_, err = f.Write(buf.Bytes())
+	_, err = f.Write(content)
 	if err != nil {
 		return false, err
 	}
@@ -2,7 +2,9 @@ package config
 
 import (
 	"encoding/json"
+	"net"
 	"os"
+	"strconv"
 )
 
 type NetboxConfig struct {
@@ -15,7 +17,116 @@ type ZoneInclude struct {
 	IncludeFiles []string `json:"include_files"`
 }
 
-type AdditionalSecondariesConfig = map[string][]string
+type DelegationNameserver struct {
+	Name string   `json:"name"`
+	IPs  []string `json:"ips"`
+}
+
+type ZoneDelegation struct {
+	Zone        string                 `json:"zone"`
+	Nameservers []DelegationNameserver `json:"nameservers"`
+	DSRecords   []string               `json:"ds_records"`
+}
+
+type DNSView struct {
+	Name         string   `json:"name"`
+	MatchClients []string `json:"match_clients"`
+}
+
+type TSIGKey struct {
+	Name      string `json:"name"`
+	Algorithm string `json:"algorithm"`
+	Secret    string `json:"secret"`
+}
+
+type DynamicUpdateConfig struct {
+	Server string  `json:"server"`
+	TSIG   TSIGKey `json:"tsig"`
+}
+
+type PowerDNSConfig struct {
+	URL        string `json:"url"`
+	ApiKey     string `json:"api_key"`
+	ServerID   string `json:"server_id"`
+	ZoneKind   string `json:"zone_kind"`
+	SQLBackend string `json:"sql_backend"`
+}
+
+type ResolverTarget struct {
+	Name          string   `json:"name"`
+	Format        string   `json:"format"`
+	Zones         []string `json:"zones"`
+	View          string   `json:"view"`
+	LocalZoneType string   `json:"local_zone_type"`
+}
+
+type DnssecPolicy struct {
+	Name            string `json:"name"`
+	Algorithm       string `json:"algorithm"`
+	KSKLifetime     string `json:"ksk_lifetime"`
+	ZSKLifetime     string `json:"zsk_lifetime"`
+	NSEC3           bool   `json:"nsec3"`
+	NSEC3Iterations int    `json:"nsec3_iterations"`
+	NSEC3OptOut     bool   `json:"nsec3_opt_out"`
+	NSEC3SaltLength int    `json:"nsec3_salt_length"`
+}
+
+type BindACL struct {
+	Name    string   `json:"name"`
+	Entries []string `json:"entries"`
+}
+
+type BindZoneOptions struct {
+	AllowQuery []string `json:"allow_query"`
+	// AllowUpdate enables dynamic updates of the zone. As nx rewrites the zone file, the zone has to be frozen while nx
+	// writes it, otherwise BIND discards the file or the updates in its journal.
+	AllowUpdate    []string `json:"allow_update"`
+	NotifyExplicit bool     `json:"notify_explicit"`
+	MaxJournalSize string   `json:"max_journal_size"`
+	Custom         []string `json:"custom"`
+}
+
+type DNSServerConfig struct {
+	Listen          string `json:"listen"`
+	RefreshInterval int    `json:"refresh_interval"`
+}
+
+type AdditionalSecondary struct {
+	Name string `json:"name"`
+	IP   string `json:"ip"`
+	Port int    `json:"port"`
+	Key  string `json:"key"`
+}
+
+// ParseAdditionalSecondary parses a secondary given as IP address or as IP address and port
+func ParseAdditionalSecondary(secondary string) AdditionalSecondary {
+	host, portString, err := net.SplitHostPort(secondary)
+	if err != nil {
+		return AdditionalSecondary{IP: secondary}
+	}
+
+	port, err := strconv.Atoi(portString)
+	if err != nil {
+		return AdditionalSecondary{IP: secondary}
+	}
+
+	return AdditionalSecondary{IP: host, Port: port}
+}
+
+// UnmarshalJSON reads the secondary from an object or from a string as in ParseAdditionalSecondary
+func (s *AdditionalSecondary) UnmarshalJSON(data []byte) error {
+	var secondary string
+	if err := json.Unmarshal(data, &secondary); err == nil {
+		*s = ParseAdditionalSecondary(secondary)
+		return nil
+	}
+
+	// the alias prevents recursing into this method
+	type additionalSecondary AdditionalSecondary
+	return json.Unmarshal(data, (*additionalSecondary)(s))
+}
+
+type AdditionalSecondariesConfig = map[string][]AdditionalSecondary
 
 type PrimaryConfig struct {
 	Name                  string                      `json:"name"`
@@ -24,16 +135,46 @@ type PrimaryConfig struct {
 	DottedEmail           string                      `json:"dotted_mail"`
 	Zones                 []string                    `json:"zones"`
 	DnssecZones           []string                    `json:"dnssec_zones"`
+	DnssecZonePolicies    map[string]string           `json:"dnssec_zone_policies"`
 	Includes              []ZoneInclude               `json:"includes"`
 	AdditionalSecondaries AdditionalSecondariesConfig `json:"additional_slaves"`
+	Delegations           []ZoneDelegation            `json:"delegations"`
+	AutoReverseZones      bool                        `json:"auto_reverse_zones"`
+	Views                 []DNSView                   `json:"views"`
+	Output                string                      `json:"output"`
+	DynamicUpdate         DynamicUpdateConfig         `json:"dynamic_update"`
+	PowerDNS              PowerDNSConfig              `json:"powerdns"`
+	Software              string                      `json:"software"`
+	TransferKey           string                      `json:"transfer_key"`
+	CatalogZone           string                      `json:"catalog_zone"`
+	DefaultZoneOptions    BindZoneOptions             `json:"default_zone_options"`
+	ZoneOptions           map[string]BindZoneOptions  `json:"zone_options"`
 }
 
 type DNSNamespaceConfig struct {
-	Primaries []PrimaryConfig `json:"masters"`
+	Primaries           []PrimaryConfig  `json:"masters"`
+	AutoReverseZones    bool             `json:"auto_reverse_zones"`
+	ConflictPolicy      string           `json:"conflict_policy"`
+	HostnamePolicy      string           `json:"hostname_policy"`
+	FallbackNamePattern string           `json:"fallback_name_pattern"`
+	SkipNameless        bool             `json:"skip_nameless"`
+	Server              DNSServerConfig  `json:"server"`
+	Resolvers           []ResolverTarget `json:"resolvers"`
+	TSIGKeys            []TSIGKey        `json:"tsig_keys"`
+	TSIGKeyStore        string           `json:"tsig_key_store"`
+	DnssecPolicies      []DnssecPolicy   `json:"dnssec_policies"`
+	ACLs                []BindACL        `json:"acls"`
+}
+
+type HostsNamespaceConfig struct {
+	Enabled bool `json:"enabled"`
+	// Split is either "zone" for one hosts file per forward zone or "combined" for a single hosts file
+	Split string `json:"split"`
 }
 
 type NamespaceConfig struct {
-	DNS DNSNamespaceConfig `json:"dns"`
+	DNS   DNSNamespaceConfig   `json:"dns"`
+	Hosts HostsNamespaceConfig `json:"hosts"`
 }
 
 type NXConfig struct {
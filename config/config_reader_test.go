@@ -0,0 +1,36 @@
+package config
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/go-test/deep"
+)
+
+func TestAdditionalSecondariesUnmarshal(t *testing.T) {
+	content := `{
+		"example.com": [
+			"192.0.2.1",
+			"192.0.2.2:5353",
+			"2001:db8::1",
+			"[2001:db8::2]:5353",
+			{"name": "provider", "ip": "198.51.100.7", "port": 5300, "key": "provider-xfr"}
+		]
+	}`
+
+	var secondaries AdditionalSecondariesConfig
+	if err := json.Unmarshal([]byte(content), &secondaries); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := AdditionalSecondariesConfig{"example.com": {
+		{IP: "192.0.2.1"},
+		{IP: "192.0.2.2", Port: 5353},
+		{IP: "2001:db8::1"},
+		{IP: "2001:db8::2", Port: 5353},
+		{Name: "provider", IP: "198.51.100.7", Port: 5300, Key: "provider-xfr"},
+	}}
+	if diff := deep.Equal(secondaries, expected); diff != nil {
+		t.Error(diff)
+	}
+}
@@ -1,7 +1,17 @@
 module peg.nu/nx
 
-go 1.13
+go 1.24.0
 
 require (
 	github.com/go-test/deep v1.0.4
+	github.com/miekg/dns v1.1.72
+	golang.org/x/net v0.48.0
+)
+
+require (
+	golang.org/x/mod v0.31.0 // indirect
+	golang.org/x/sync v0.19.0 // indirect
+	golang.org/x/sys v0.39.0 // indirect
+	golang.org/x/text v0.32.0 // indirect
+	golang.org/x/tools v0.40.0 // indirect
 )
@@ -14,15 +14,39 @@ import (
 	"peg.nu/nx/config"
 	"peg.nu/nx/netbox"
 	"peg.nu/nx/ns/dns"
+	"peg.nu/nx/ns/hosts"
 	"peg.nu/nx/ns/wg"
 )
 
 var logger = log.New(os.Stdout, "[main] ", log.LstdFlags)
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "validate" {
+		err := dns.ValidateZoneFiles("generated/zones")
+		if err != nil {
+			logger.Fatal(err)
+		}
+		return
+	}
+
 	conf := config.ReadConfig("./config.json")
 
-	nc := netbox.New(conf)
+	if len(os.Args) > 1 && os.Args[1] == "serve" {
+		serve(&conf)
+		return
+	}
+
+	_, err := run(&conf)
+	if err != nil {
+		logger.Fatal(err)
+	}
+}
+
+// run loads the addresses from NetBox, generates all outputs and returns the generated zones
+func run(conf *config.NXConfig) ([]dns.Zone, error) {
+	conf.UpdatedFiles = nil
+
+	nc := netbox.New(*conf)
 	logger.Println("Loading prefixes")
 	prefixes := nc.GetIPAMPrefixes()
 	if len(prefixes) == 0 {
@@ -33,16 +57,66 @@ func main() {
 
 	prefixIPsList := loadPrefixes(prefixes, nc)
 	sortPrefixList(prefixIPsList)
-	generateAll(prefixIPsList, dnsIps, wgIps, iplIps, &conf)
+	generatedZones := generateAll(prefixIPsList, dnsIps, wgIps, iplIps, conf)
 
 	logger.Println("Writing updated files report")
 	err := os.WriteFile("generated/updated_files.txt", []byte(strings.Join(conf.UpdatedFiles, "\n")), os.ModePerm)
 	if err != nil {
-		logger.Fatal(err)
+		return nil, err
 	}
 	if len(conf.UpdatedFiles) > 0 {
 		err = os.WriteFile("generated/last_modified.txt", []byte(time.Now().Format(time.RFC3339)), os.ModePerm)
 	}
+
+	logger.Println("Pushing dynamic updates")
+	err = dns.PushDynamicUpdates(generatedZones, conf)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Println("Pushing PowerDNS changes")
+	err = dns.PushPowerDNS(generatedZones, conf)
+	if err != nil {
+		return nil, err
+	}
+
+	// the records are only stored once the run succeeded, so the next change report covers all changes since then
+	dns.SaveRecordsState(generatedZones)
+
+	return generatedZones, nil
+}
+
+// serve runs the built-in nameserver and regenerates the served zones periodically. The previous zones are served
+// until a generation succeeds.
+func serve(conf *config.NXConfig) {
+	server := dns.NewServer(conf)
+	go func() {
+		logger.Fatal(server.ListenAndServe(dns.ServerListenAddress(conf)))
+	}()
+	logger.Printf("Serving DNS on %s\n", dns.ServerListenAddress(conf))
+
+	for {
+		generatedZones, err := tryRun(conf)
+		if err == nil {
+			err = server.Update(generatedZones)
+		}
+		if err != nil {
+			logger.Printf("Generation failed: %v", err)
+		}
+
+		time.Sleep(dns.ServerRefreshInterval(conf))
+	}
+}
+
+// tryRun runs a generation and converts a panic into an error
+func tryRun(conf *config.NXConfig) (generatedZones []dns.Zone, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+
+	return run(conf)
 }
 
 func loadPrefixes(prefixes []model.IPAMPrefix, nc netbox.Client) []prefixIPs {
@@ -81,7 +155,7 @@ func sortPrefixList(prefixIPsList []prefixIPs) {
 	}
 }
 
-func generateAll(prefixIPsList []prefixIPs, dnsIps []model.IPAddress, wgIps []model.IPAddress, iplIps []model.IPAddress, conf *config.NXConfig) {
+func generateAll(prefixIPsList []prefixIPs, dnsIps []model.IPAddress, wgIps []model.IPAddress, iplIps []model.IPAddress, conf *config.NXConfig) []dns.Zone {
 	defer util.DurationSince(util.StartTracking("generateAll"))
 
 	for _, prefixIP := range prefixIPsList {
@@ -110,10 +184,18 @@ func generateAll(prefixIPsList []prefixIPs, dnsIps []model.IPAddress, wgIps []mo
 
 	logger.Println("Generating BIND config files")
 	dns.GenerateConfigs(generatedZones, conf)
+	logger.Println("Generating PowerDNS SQL files")
+	dns.GeneratePowerDNSSQL(generatedZones, conf)
+	logger.Println("Generating resolver config files")
+	dns.GenerateResolverConfigs(generatedZones, conf)
 	logger.Println("Generating Wireguard config files")
 	wg.GenerateWgConfigs(wgIps, conf)
 	logger.Println("Generating IP lists")
 	ipl.GenerateIPLists(iplIps, conf)
+	logger.Println("Generating hosts files")
+	hosts.GenerateHostsFiles(dnsIps, generatedZones, conf)
+
+	return generatedZones
 }
 
 type prefixIPs struct {
@@ -0,0 +1,185 @@
+package dns
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+
+	mdns "github.com/miekg/dns"
+	"peg.nu/nx/cache"
+	"peg.nu/nx/config"
+	"peg.nu/nx/util"
+)
+
+// catalogSchemaVersion is the version of the catalog zone schema defined in RFC 9432
+const catalogSchemaVersion = "2"
+
+type catalogMember struct {
+	ID   string
+	Zone string
+}
+
+type catalogTemplateVars struct {
+	ZoneName    string
+	View        string
+	GeneratedAt string
+	Version     string
+	SOAInfo     SOAInfo
+	Members     []catalogMember
+}
+
+// templateCatalog is a catalog zone of another primary consumed by a secondary
+type templateCatalog struct {
+	Name        string
+	PrimaryIP   string
+	PrimaryPort int
+	TransferKey string
+	// ViewKey is used instead of TransferKey in views the primary of the catalog zone also has
+	ViewKey string
+	// primaryViews are the views of the primary of the catalog zone
+	primaryViews []string
+}
+
+type catalogZonesTemplateVars struct {
+	ServerName  string
+	GeneratedAt string
+	Catalogs    []templateCatalog
+}
+
+// hasCatalogZone reports whether nx generates a catalog zone for the zones of the primary
+func hasCatalogZone(primary *config.PrimaryConfig) bool {
+	return len(primary.CatalogZone) > 0 && primaryOutput(primary) == outputFiles && primarySoftware(primary) == softwareBind
+}
+
+// warnUnsupportedCatalogZones logs the catalog zones configured for nameservers nx does not generate catalog zones for
+func warnUnsupportedCatalogZones(conf *config.NXConfig) {
+	for _, primary := range conf.Namespaces.DNS.Primaries {
+		if len(primary.CatalogZone) > 0 && !hasCatalogZone(&primary) {
+			logger.Printf("Ignoring catalog zone %s of nameserver %s, catalog zones are only generated for %s with the %s output", primary.CatalogZone, primary.Name, softwareBind, outputFiles)
+		}
+	}
+}
+
+// catalogMemberID returns the unique label of the member zone, the SHA-1 hash of the zone name in wire format like
+// BIND uses for the zones it adds to catalog zones
+func catalogMemberID(zone string) string {
+	wire := make([]byte, 256)
+	length, err := mdns.PackDomainName(mdns.Fqdn(strings.ToLower(zone)), wire, 0, nil, false)
+	if err != nil {
+		panic(fmt.Errorf("could not pack zone name %s: %w", zone, err))
+	}
+
+	hash := sha1.Sum(wire[:length])
+	return hex.EncodeToString(hash[:])
+}
+
+// catalogMembers returns the generated zones of the primary
+func catalogMembers(primary *config.PrimaryConfig, zones []string) []catalogMember {
+	var members []catalogMember
+	for _, zone := range primary.Zones {
+		if util.SliceContainsString(zones, zone) {
+			members = append(members, catalogMember{ID: catalogMemberID(zone), Zone: zone})
+		}
+	}
+
+	return members
+}
+
+// primaryCatalog returns the catalog zone of the primary as consumed by its secondaries
+func primaryCatalog(primary *config.PrimaryConfig) templateCatalog {
+	return templateCatalog{Name: primary.CatalogZone, PrimaryIP: primary.IP, PrimaryPort: primary.Port, TransferKey: primary.TransferKey, primaryViews: primaryViewNames(primary)}
+}
+
+// withViewKeys returns a copy of the catalogs transferred with the view key if their primary has the view
+func withViewKeys(catalogs []templateCatalog, view string) []templateCatalog {
+	viewCatalogs := make([]templateCatalog, 0, len(catalogs))
+	for _, catalog := range catalogs {
+		if util.SliceContainsString(catalog.primaryViews, view) {
+			catalog.ViewKey = viewKeyName(view)
+		}
+		viewCatalogs = append(viewCatalogs, catalog)
+	}
+
+	return viewCatalogs
+}
+
+// renderCatalogZones renders the catalog zone of every primary with one, once for every view of the primary
+func renderCatalogZones(zones []Zone, defaultSoaInfo SOAInfo, generatedAt string, conf *config.NXConfig) (*cache.CachedTemplateWriter, []stagedFile) {
+	templateString, err := os.ReadFile("templates/bind-catalog.tmpl")
+	if err != nil {
+		panic(err)
+	}
+	catalogTemplate := template.Must(template.New("catalog").Parse(string(templateString)))
+	ignoreRegexes := []*regexp.Regexp{
+		regexp.MustCompile("(?m)^; Generated at .*$"),
+		regexp.MustCompile("(?m)^\\s+\\d+\\s+; serial.*$"),
+	}
+	cw := cache.New(catalogTemplate, ignoreRegexes, true)
+	var staged []stagedFile
+
+	zoneNames := ZoneNames(zones)
+	for _, primary := range conf.Namespaces.DNS.Primaries {
+		if !hasCatalogZone(&primary) {
+			continue
+		}
+
+		vars := catalogTemplateVars{
+			ZoneName:    primary.CatalogZone,
+			GeneratedAt: generatedAt,
+			Version:     catalogSchemaVersion,
+			SOAInfo:     defaultSoaInfo,
+			Members:     catalogMembers(&primary, zoneNames),
+		}
+		vars.SOAInfo.NameserverFQDN = fmt.Sprintf("%s.", primary.Name)
+		vars.SOAInfo.DottedMailResponsible = primary.DottedEmail
+
+		views := primaryViewNames(&primary)
+		if len(views) == 0 {
+			views = []string{""}
+		}
+		for _, view := range views {
+			vars.View = view
+			content, err := cw.RenderTemplate(vars)
+			if err != nil {
+				panic(err)
+			}
+			staged = append(staged, stagedFile{writer: cw, path: fmt.Sprintf("generated/zones/%s", zoneFileName(primary.CatalogZone, view)), content: content})
+		}
+	}
+
+	return cw, staged
+}
+
+// catalogZonesFileName returns the name of the file containing the catalog-zones statement of the nameserver, which
+// is only valid in the options block
+func catalogZonesFileName(serverName string) string {
+	return fmt.Sprintf("%s.catalog-zones.conf", serverName)
+}
+
+func newCatalogZonesWriter() *cache.CachedTemplateWriter {
+	templateString, err := os.ReadFile("templates/bind-catalog-zones.tmpl")
+	if err != nil {
+		panic(err)
+	}
+	catalogZonesTemplate := template.Must(template.New("catalog-zones").Parse(string(templateString)))
+	ignoreRegexes := []*regexp.Regexp{
+		regexp.MustCompile("(?m)^ \\* Generated at.*$"),
+	}
+
+	return cache.New(catalogZonesTemplate, ignoreRegexes, false)
+}
+
+func writeCatalogZonesFile(cw *cache.CachedTemplateWriter, serverName string, catalogs []templateCatalog) {
+	_, err := cw.WriteTemplate(
+		fmt.Sprintf("generated/bind-config/%s", catalogZonesFileName(serverName)),
+		catalogZonesTemplateVars{ServerName: serverName, GeneratedAt: time.Now().Format(time.RFC3339), Catalogs: catalogs},
+	)
+	if err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,47 @@
+package dns
+
+import (
+	"testing"
+
+	"github.com/go-test/deep"
+	"peg.nu/nx/config"
+)
+
+func TestCatalogMemberID(t *testing.T) {
+	expected := "c5e4b4da1e5a620ddaa3635e55c3732a5b49c7f4"
+	for _, zone := range []string{"example.com", "Example.COM."} {
+		if actual := catalogMemberID(zone); actual != expected {
+			t.Errorf("Expected member id <%s> for <%s>; but was <%s>", expected, zone, actual)
+		}
+	}
+}
+
+func TestCatalogMembers(t *testing.T) {
+	primary := config.PrimaryConfig{Zones: []string{"example.com", "empty.example.com", "168.192.in-addr.arpa"}}
+	expected := []catalogMember{
+		{ID: catalogMemberID("example.com"), Zone: "example.com"},
+		{ID: catalogMemberID("168.192.in-addr.arpa"), Zone: "168.192.in-addr.arpa"},
+	}
+
+	if diff := deep.Equal(catalogMembers(&primary, []string{"168.192.in-addr.arpa", "example.com", "example.net"}), expected); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestHasCatalogZone(t *testing.T) {
+	tests := []struct {
+		primary  config.PrimaryConfig
+		expected bool
+	}{
+		{config.PrimaryConfig{}, false},
+		{config.PrimaryConfig{CatalogZone: "catalog.example.com"}, true},
+		{config.PrimaryConfig{CatalogZone: "catalog.example.com", Software: "knot"}, false},
+		{config.PrimaryConfig{CatalogZone: "catalog.example.com", Output: "dynamic_update"}, false},
+	}
+
+	for _, test := range tests {
+		if actual := hasCatalogZone(&test.primary); actual != test.expected {
+			t.Errorf("Expected <%v> for <%+v>; but was <%v>", test.expected, test.primary, actual)
+		}
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"os"
 	"regexp"
+	"sort"
 	"text/template"
 	"time"
 
@@ -23,13 +24,28 @@ const (
 
 type templateZone struct {
 	Name            string
+	FileName        string
+	TransferSource  string
 	Type            zoneType
 	IsSecondary     bool
 	IsDnssecEnabled bool
-	PrimaryIP       string
-	PrimaryPort     int
+	DnssecPolicy    string
+	// SigningView is the view the zone is signed in, BIND requires a separate key directory per view
+	SigningView string
+	PrimaryIP   string
+	PrimaryPort int
+	TransferKey string
+	// ViewKey selects the view of the zone on the other primaries, it is used instead of TransferKey in views
+	ViewKey         string
 	TransferAcls    []string
 	NotifyPrimaries []string
+	AllowQuery      []string
+	AllowUpdate     []string
+	NotifyExplicit  bool
+	MaxJournalSize  string
+	CustomOptions   []string
+	// primaryViews are the views of the primary of the zone
+	primaryViews []string
 }
 
 type aclPrimaryType string
@@ -42,6 +58,10 @@ const (
 type primaryIPAndPort struct {
 	IP   string
 	Port int
+	// Key is the TSIG key the server authenticates with, ACLs match the key instead of the IP if it is set
+	Key string
+	// Name is the descriptive name of the server
+	Name string
 }
 
 type aclPrimaryList struct {
@@ -50,12 +70,46 @@ type aclPrimaryList struct {
 	Entries []primaryIPAndPort
 }
 
+type templateView struct {
+	Name         string
+	MatchClients []string
+	// Servers are the other primaries with the view, all messages sent to them from the view are signed with the view key
+	Servers  []keyedServer
+	Zones    []templateZone
+	Catalogs []templateCatalog
+}
+
 type configTemplateVars struct {
 	ServerName      string
 	ServerIP        string
 	GeneratedAt     string
 	Zones           []templateZone
+	Views           []templateView
 	AclPrimaryLists []aclPrimaryList
+	KeysFile        string
+	Servers         []keyedServer
+	// Catalogs are the catalog zones of other primaries, configured in the views or in CatalogZonesFile
+	Catalogs         []templateCatalog
+	CatalogZonesFile string
+	DnssecPolicies   []templateDnssecPolicy
+}
+
+// withFileNames returns a copy of the zones with the file names of the given view. In a view, zones of primaries with
+// the same view are transferred with the view key.
+func withFileNames(zones []templateZone, view string) []templateZone {
+	viewZones := make([]templateZone, 0, len(zones))
+	for _, zone := range zones {
+		zone.FileName = zoneFileName(zone.Name, view)
+		if len(view) > 0 && util.SliceContainsString(zone.primaryViews, view) {
+			zone.ViewKey = viewKeyName(view)
+		}
+		if len(view) > 0 && zone.IsDnssecEnabled && !zone.IsSecondary {
+			zone.SigningView = view
+		}
+		viewZones = append(viewZones, zone)
+	}
+
+	return viewZones
 }
 
 const defaultAclName = "nx-secondary-acl"
@@ -80,14 +134,26 @@ func getAdditionalAclPrimaryName(zone string, ty aclPrimaryType) string {
 	return fmt.Sprintf("nx-secondary-%s-%s", ty, canonicalZone)
 }
 
+// additionalSecondaryZones returns the zones of the primary with additional secondaries in a stable order
+func additionalSecondaryZones(primaryConfig *config.PrimaryConfig) []string {
+	zones := make([]string, 0, len(primaryConfig.AdditionalSecondaries))
+	for zone := range primaryConfig.AdditionalSecondaries {
+		zones = append(zones, zone)
+	}
+	sort.Strings(zones)
+
+	return zones
+}
+
 func generateAdditionalAclPrimaryLists(primaryConfig *config.PrimaryConfig) []aclPrimaryList {
 	var lists []aclPrimaryList
 
 	if primaryConfig.AdditionalSecondaries != nil {
-		for zone, secondaries := range primaryConfig.AdditionalSecondaries {
+		for _, zone := range additionalSecondaryZones(primaryConfig) {
+			secondaries := primaryConfig.AdditionalSecondaries[zone]
 			var secondariesWithPorts []primaryIPAndPort
 			for _, secondary := range secondaries {
-				secondariesWithPorts = append(secondariesWithPorts, primaryIPAndPort{IP: secondary}) // port is empty for now
+				secondariesWithPorts = append(secondariesWithPorts, primaryIPAndPort{IP: secondary.IP, Port: secondary.Port, Key: secondary.Key, Name: secondary.Name})
 			}
 
 			lists = append(lists, []aclPrimaryList{
@@ -100,7 +166,9 @@ func generateAdditionalAclPrimaryLists(primaryConfig *config.PrimaryConfig) []ac
 	return lists
 }
 
-func GenerateConfigs(zones []string, conf *config.NXConfig) {
+func GenerateConfigs(generatedZones []Zone, conf *config.NXConfig) {
+	zones := ZoneNames(generatedZones)
+
 	templateString, err := os.ReadFile("templates/bind-config.tmpl")
 	if err != nil {
 		panic(err)
@@ -111,13 +179,24 @@ func GenerateConfigs(zones []string, conf *config.NXConfig) {
 	}
 	cw := cache.New(configTemplate, ignoreRegexes, false)
 
+	warnUnsupportedCatalogZones(conf)
+	keysWriter := newKeysWriter(softwareBind)
+	catalogZonesWriter := newCatalogZonesWriter()
+	transferKeys := resolveTransferKeys(conf)
+
 	templateVars := configTemplateVars{
 		GeneratedAt: time.Now().Format(time.RFC3339),
 	}
 	for _, currentPrimary := range conf.Namespaces.DNS.Primaries {
+		if primaryOutput(&currentPrimary) != outputFiles || primarySoftware(&currentPrimary) != softwareBind {
+			// the nameserver is not managed by nx or not running BIND
+			continue
+		}
+
 		templateVars.ServerName = currentPrimary.Name
 		templateVars.ServerIP = currentPrimary.IP
 		var templateZones []templateZone
+		var catalogs []templateCatalog
 
 		for _, zonesPrimary := range conf.Namespaces.DNS.Primaries {
 			isPrimary := zonesPrimary.Name == currentPrimary.Name
@@ -126,12 +205,29 @@ func GenerateConfigs(zones []string, conf *config.NXConfig) {
 				serverZoneType = zoneSecondary
 			}
 
+			var primaryZones []string
 			for _, zone := range zonesPrimary.Zones {
-				if !util.SliceContainsString(zones, zone) {
-					continue
+				if util.SliceContainsString(zones, zone) {
+					primaryZones = append(primaryZones, zone)
+				}
+			}
+			if hasCatalogZone(&zonesPrimary) {
+				if isPrimary {
+					primaryZones = append(primaryZones, zonesPrimary.CatalogZone)
+				} else {
+					// the member zones are provisioned from the catalog zone
+					primaryZones = []string{zonesPrimary.CatalogZone}
+					catalogs = append(catalogs, primaryCatalog(&zonesPrimary))
 				}
+			}
 
+			for _, zone := range primaryZones {
+
+				// the other primaries authenticate with the transfer key instead of their IP if the zone has one
 				transferAcls := []string{defaultAclName}
+				if len(zonesPrimary.TransferKey) > 0 {
+					transferAcls = nil
+				}
 				notifyPrimaries := []string{defaultPrimariesName}
 
 				_, hasAdditionalSecondaries := currentPrimary.AdditionalSecondaries[zone]
@@ -140,22 +236,48 @@ func GenerateConfigs(zones []string, conf *config.NXConfig) {
 					notifyPrimaries = append(notifyPrimaries, getAdditionalAclPrimaryName(zone, listPrimaries))
 				}
 
-				dnssecEnabled := util.SliceContainsString(zonesPrimary.DnssecZones, zone)
+				dnssecPolicy, dnssecEnabled := zoneDnssecPolicy(&zonesPrimary, zone)
 
-				templateZones = append(templateZones, templateZone{
+				tZone := templateZone{
+					TransferSource:  currentPrimary.IP,
 					IsSecondary:     !isPrimary,
 					IsDnssecEnabled: dnssecEnabled,
+					DnssecPolicy:    dnssecPolicy,
 					PrimaryIP:       zonesPrimary.IP,
 					PrimaryPort:     zonesPrimary.Port,
+					TransferKey:     zonesPrimary.TransferKey,
 					Name:            zone,
 					Type:            serverZoneType,
 					TransferAcls:    transferAcls,
 					NotifyPrimaries: notifyPrimaries,
-				})
+					primaryViews:    primaryViewNames(&zonesPrimary),
+				}
+				applyZoneOptions(&tZone, &currentPrimary, conf.Namespaces.DNS.ACLs)
+				templateZones = append(templateZones, tZone)
 			}
 		}
 
-		templateVars.Zones = templateZones
+		templateVars.Zones = nil
+		templateVars.Views = nil
+		templateVars.DnssecPolicies = primaryDnssecPolicies(&currentPrimary, zones, conf)
+		templateVars.Catalogs = catalogs
+		templateVars.CatalogZonesFile = ""
+		if len(catalogs) > 0 && len(currentPrimary.Views) == 0 {
+			writeCatalogZonesFile(catalogZonesWriter, currentPrimary.Name, catalogs)
+			templateVars.CatalogZonesFile = catalogZonesFileName(currentPrimary.Name)
+		}
+		if len(currentPrimary.Views) == 0 {
+			templateVars.Zones = withFileNames(templateZones, "")
+		}
+		for _, view := range currentPrimary.Views {
+			templateVars.Views = append(templateVars.Views, templateView{
+				Name:         view.Name,
+				MatchClients: viewMatchClients(view.Name, &currentPrimary),
+				Servers:      viewServers(view.Name, &currentPrimary, conf),
+				Zones:        withFileNames(templateZones, view.Name),
+				Catalogs:     withViewKeys(catalogs, view.Name),
+			})
+		}
 
 		var primaryIpsWithoutCurrent = make([]primaryIPAndPort, 0, len(conf.Namespaces.DNS.Primaries)-1)
 		for _, primary := range conf.Namespaces.DNS.Primaries {
@@ -166,8 +288,20 @@ func GenerateConfigs(zones []string, conf *config.NXConfig) {
 
 		aclPrimaryLists := generateStandardAclPrimaryLists(primaryIpsWithoutCurrent)
 		aclPrimaryLists = append(aclPrimaryLists, generateAdditionalAclPrimaryLists(&currentPrimary)...)
+		aclPrimaryLists = append(aclPrimaryLists, configuredAclLists(conf.Namespaces.DNS.ACLs)...)
 		templateVars.AclPrimaryLists = aclPrimaryLists
 
+		keys, servers := primaryKeys(currentPrimary, transferKeys, conf)
+		for _, view := range primaryViewNames(&currentPrimary) {
+			keys = append(keys, transferKeys[viewKeyName(view)])
+		}
+		templateVars.KeysFile = ""
+		templateVars.Servers = servers
+		if len(keys) > 0 {
+			writeKeysFile(keysWriter, "generated/bind-config", currentPrimary.Name, keys)
+			templateVars.KeysFile = keysFileName(currentPrimary.Name)
+		}
+
 		_, err = cw.WriteTemplate(
 			fmt.Sprintf("generated/bind-config/%s.conf", currentPrimary.Name),
 			templateVars,
@@ -177,6 +311,12 @@ func GenerateConfigs(zones []string, conf *config.NXConfig) {
 		}
 	}
 
-	util.CleanDirectoryExcept("generated/bind-config", cw.ProcessedFiles, conf)
+	processedFiles := append(cw.ProcessedFiles, keysWriter.ProcessedFiles...)
+	processedFiles = append(processedFiles, catalogZonesWriter.ProcessedFiles...)
+	util.CleanDirectoryExcept("generated/bind-config", processedFiles, conf)
 	conf.UpdatedFiles = append(conf.UpdatedFiles, cw.UpdatedFiles...)
+	conf.UpdatedFiles = append(conf.UpdatedFiles, keysWriter.UpdatedFiles...)
+	conf.UpdatedFiles = append(conf.UpdatedFiles, catalogZonesWriter.UpdatedFiles...)
+
+	generateNativeConfigs(zones, transferKeys, conf)
 }
@@ -0,0 +1,225 @@
+package dns
+
+import (
+	"fmt"
+	"strings"
+)
+
+type conflictPolicy string
+
+const (
+	// conflictError fails the generation if any conflict is found
+	conflictError conflictPolicy = "error"
+	// conflictWarn logs conflicts and skips the conflicting records
+	conflictWarn conflictPolicy = "warn"
+	// conflictRoundRobin behaves like conflictWarn, but allows multiple A/AAAA records for the same name
+	conflictRoundRobin conflictPolicy = "round_robin"
+)
+
+func parseConflictPolicy(policy string) conflictPolicy {
+	switch conflictPolicy(policy) {
+	case conflictError, conflictWarn, conflictRoundRobin:
+		return conflictPolicy(policy)
+	case "":
+		return conflictRoundRobin
+	}
+
+	logger.Printf("Unknown conflict policy <%s>, using %s", policy, conflictRoundRobin)
+	return conflictRoundRobin
+}
+
+type conflictKind string
+
+const (
+	conflictMultipleAddresses conflictKind = "multiple_addresses"
+	conflictCNameAndOtherData conflictKind = "cname_and_other_data"
+	conflictMultiplePtr       conflictKind = "multiple_ptr"
+	conflictCNameAtApex       conflictKind = "cname_at_apex"
+	// conflictWildcardShadowed is reported for names with records of their own below a wildcard, the wildcard does not
+	// apply to them
+	conflictWildcardShadowed conflictKind = "wildcard_shadowed"
+)
+
+type conflictAction string
+
+const (
+	actionAllowed conflictAction = "allowed"
+	actionSkipped conflictAction = "skipped"
+	actionError   conflictAction = "error"
+)
+
+type conflictRecord struct {
+	Type      rrType `json:"type"`
+	RData     string `json:"rdata"`
+	AddressID int    `json:"netbox_address_id,omitempty"`
+	Skipped   bool   `json:"skipped"`
+}
+
+type recordConflict struct {
+	Zone    string           `json:"zone"`
+	View    string           `json:"view,omitempty"`
+	Name    string           `json:"name"`
+	Kind    conflictKind     `json:"kind"`
+	Action  conflictAction   `json:"action"`
+	Records []conflictRecord `json:"records"`
+}
+
+// resolveConflicts removes duplicate records and applies the policy to records violating RFCs. The returned error is
+// only set if the policy is conflictError and conflicts were found.
+func resolveConflicts(zones []Zone, policy conflictPolicy) ([]recordConflict, error) {
+	var conflicts []recordConflict
+	for i := range zones {
+		records, zoneConflicts := resolveZoneConflicts(zones[i].Name, zones[i].Records, policy)
+		zones[i].Records = records
+		for _, conflict := range zoneConflicts {
+			conflict.View = zones[i].View
+			conflicts = append(conflicts, conflict)
+		}
+	}
+
+	for _, conflict := range conflicts {
+		logger.Printf("Conflict (%s) for %s in zone %s (view: %s) involving %d records: %s", conflict.Kind, conflict.Name, conflict.Zone, conflict.View, len(conflict.Records), conflict.Action)
+	}
+
+	// allowed conflicts like shadowed wildcards are only reported
+	failed := 0
+	for _, conflict := range conflicts {
+		if conflict.Action != actionAllowed {
+			failed++
+		}
+	}
+	if policy == conflictError && failed > 0 {
+		return conflicts, fmt.Errorf("found %d record conflict(s)", failed)
+	}
+	return conflicts, nil
+}
+
+func resolveZoneConflicts(zone string, records []resourceRecord, policy conflictPolicy) ([]resourceRecord, []recordConflict) {
+	var owners []string
+	ownerRecords := make(map[string][]resourceRecord)
+	for _, record := range records {
+		existing, ok := ownerRecords[record.Name]
+		if !ok {
+			owners = append(owners, record.Name)
+		}
+		if containsRecord(existing, record) {
+			continue
+		}
+		ownerRecords[record.Name] = append(existing, record)
+	}
+
+	var conflicts []recordConflict
+	var resolved []resourceRecord
+	for _, owner := range owners {
+		skipped := make(map[int]bool)
+		addConflict := func(kind conflictKind, involved []int, skip []int, allowed bool) {
+			action := actionSkipped
+			if policy == conflictError {
+				action = actionError
+			} else if allowed {
+				action = actionAllowed
+			}
+
+			skipSet := make(map[int]bool)
+			if action == actionSkipped {
+				for _, idx := range skip {
+					skipSet[idx] = true
+					skipped[idx] = true
+				}
+			}
+
+			conflict := recordConflict{Zone: zone, Name: owner, Kind: kind, Action: action}
+			for _, idx := range involved {
+				record := ownerRecords[owner][idx]
+				conflict.Records = append(conflict.Records, conflictRecord{Type: record.Type, RData: record.RData, AddressID: record.AddressID, Skipped: skipSet[idx]})
+			}
+			conflicts = append(conflicts, conflict)
+		}
+
+		byType := make(map[rrType][]int)
+		for idx, record := range ownerRecords[owner] {
+			byType[record.Type] = append(byType[record.Type], idx)
+		}
+
+		cnames := byType[CName]
+		if owner == apexName && len(cnames) > 0 {
+			// the apex always has SOA and NS records
+			addConflict(conflictCNameAtApex, cnames, cnames, false)
+			cnames = nil
+		}
+		if len(cnames) > 0 && (len(cnames) > 1 || len(ownerRecords[owner]) > len(cnames)) {
+			involved := make([]int, 0, len(ownerRecords[owner]))
+			for idx := range ownerRecords[owner] {
+				involved = append(involved, idx)
+			}
+
+			// keep the other data and drop all CNAMEs, or keep only the first CNAME if there is no other data
+			skip := cnames
+			if len(ownerRecords[owner]) == len(cnames) {
+				skip = cnames[1:]
+			}
+			addConflict(conflictCNameAndOtherData, involved, skip, false)
+		}
+
+		for _, addressType := range []rrType{A, Aaaa} {
+			if involved := byType[addressType]; hasMultipleSources(ownerRecords[owner], involved) {
+				addConflict(conflictMultipleAddresses, involved, involved[1:], policy == conflictRoundRobin)
+			}
+		}
+
+		if involved := byType[Ptr]; len(involved) > 1 {
+			addConflict(conflictMultiplePtr, involved, involved[1:], false)
+		}
+
+		for idx, record := range ownerRecords[owner] {
+			if !skipped[idx] {
+				resolved = append(resolved, record)
+			}
+		}
+	}
+
+	conflicts = append(conflicts, wildcardConflicts(zone, owners, ownerRecords)...)
+
+	return resolved, conflicts
+}
+
+// wildcardConflicts reports the names below the wildcards of the zone, which are not covered by the wildcards
+func wildcardConflicts(zone string, owners []string, ownerRecords map[string][]resourceRecord) []recordConflict {
+	var conflicts []recordConflict
+	for _, wildcard := range owners {
+		parent, ok := wildcardParent(wildcard)
+		if !ok {
+			continue
+		}
+
+		for _, owner := range owners {
+			if _, isWildcard := wildcardParent(owner); isWildcard || owner == apexName {
+				continue
+			}
+			if parent != apexName && !strings.HasSuffix(owner, "."+parent) {
+				continue
+			}
+
+			// the records of the wildcard come first, followed by the records of the name taking precedence
+			conflict := recordConflict{Zone: zone, Name: owner, Kind: conflictWildcardShadowed, Action: actionAllowed}
+			for _, record := range append(append([]resourceRecord{}, ownerRecords[wildcard]...), ownerRecords[owner]...) {
+				conflict.Records = append(conflict.Records, conflictRecord{Type: record.Type, RData: record.RData, AddressID: record.AddressID})
+			}
+			conflicts = append(conflicts, conflict)
+		}
+	}
+
+	return conflicts
+}
+
+// hasMultipleSources returns true if the records at the given indexes originate from more than one NetBox address or
+// the config
+func hasMultipleSources(records []resourceRecord, indexes []int) bool {
+	for _, idx := range indexes {
+		if records[idx].AddressID != records[indexes[0]].AddressID {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,135 @@
+package dns
+
+import (
+	"testing"
+
+	"github.com/go-test/deep"
+)
+
+func conflictTestRecords() []resourceRecord {
+	return []resourceRecord{
+		{Name: "web", Type: A, RData: "10.0.0.5", AddressID: 1},
+		{Name: "web", Type: A, RData: "10.0.0.6", AddressID: 2},
+		{Name: "web", Type: A, RData: "10.0.0.5", AddressID: 1},
+		{Name: "www", Type: CName, RData: "web", AddressID: 1},
+		{Name: "www", Type: A, RData: "10.0.0.7", AddressID: 3},
+		{Name: "ns1.lab", Type: A, RData: "10.0.0.53"},
+		{Name: "ns1.lab", Type: A, RData: "10.0.0.54"},
+	}
+}
+
+func TestConflictRoundRobin(t *testing.T) {
+	resolved, conflicts := resolveZoneConflicts("example.com", conflictTestRecords(), conflictRoundRobin)
+
+	expected := []resourceRecord{
+		{Name: "web", Type: A, RData: "10.0.0.5", AddressID: 1},
+		{Name: "web", Type: A, RData: "10.0.0.6", AddressID: 2},
+		{Name: "www", Type: A, RData: "10.0.0.7", AddressID: 3},
+		{Name: "ns1.lab", Type: A, RData: "10.0.0.53"},
+		{Name: "ns1.lab", Type: A, RData: "10.0.0.54"},
+	}
+	if diff := deep.Equal(expected, resolved); diff != nil {
+		t.Error(diff)
+	}
+
+	expectedConflicts := []recordConflict{
+		{Zone: "example.com", Name: "web", Kind: conflictMultipleAddresses, Action: actionAllowed, Records: []conflictRecord{
+			{Type: A, RData: "10.0.0.5", AddressID: 1},
+			{Type: A, RData: "10.0.0.6", AddressID: 2},
+		}},
+		{Zone: "example.com", Name: "www", Kind: conflictCNameAndOtherData, Action: actionSkipped, Records: []conflictRecord{
+			{Type: CName, RData: "web", AddressID: 1, Skipped: true},
+			{Type: A, RData: "10.0.0.7", AddressID: 3},
+		}},
+	}
+	if diff := deep.Equal(expectedConflicts, conflicts); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestConflictWarn(t *testing.T) {
+	resolved, conflicts := resolveZoneConflicts("example.com", conflictTestRecords(), conflictWarn)
+
+	expected := []resourceRecord{
+		{Name: "web", Type: A, RData: "10.0.0.5", AddressID: 1},
+		{Name: "www", Type: A, RData: "10.0.0.7", AddressID: 3},
+		{Name: "ns1.lab", Type: A, RData: "10.0.0.53"},
+		{Name: "ns1.lab", Type: A, RData: "10.0.0.54"},
+	}
+	if diff := deep.Equal(expected, resolved); diff != nil {
+		t.Error(diff)
+	}
+	if len(conflicts) != 2 || conflicts[0].Action != actionSkipped {
+		t.Errorf("Expected 2 skipped conflicts; but got %v", conflicts)
+	}
+}
+
+func TestConflictError(t *testing.T) {
+	zones := []Zone{
+		{Name: "2.0.192.in-addr.arpa", Records: []resourceRecord{
+			{Name: "5", Type: Ptr, RData: "web.example.com.", AddressID: 1},
+			{Name: "5", Type: Ptr, RData: "mail.example.com.", AddressID: 4},
+		}},
+		{Name: "example.com", Records: conflictTestRecords()},
+	}
+
+	conflicts, err := resolveConflicts(zones, conflictError)
+	if err == nil {
+		t.Errorf("Expected conflicts to fail the generation")
+	}
+	if len(conflicts) != 3 || conflicts[0].Kind != conflictMultiplePtr || conflicts[0].Action != actionError {
+		t.Errorf("Expected 3 conflicts starting with the PTR conflict; but got %v", conflicts)
+	}
+	if len(zones[0].Records) != 2 {
+		t.Errorf("Expected no records to be skipped")
+	}
+}
+
+func TestConflictApexAndWildcard(t *testing.T) {
+	records := []resourceRecord{
+		{Name: "@", Type: CName, RData: "web", AddressID: 1},
+		{Name: "@", Type: A, RData: "10.0.0.5", AddressID: 2},
+		{Name: "*.apps", Type: A, RData: "10.0.0.8", AddressID: 3},
+		{Name: "api.apps", Type: A, RData: "10.0.0.9", AddressID: 4},
+		{Name: "apps", Type: A, RData: "10.0.0.10", AddressID: 5},
+	}
+	resolved, conflicts := resolveZoneConflicts("example.com", records, conflictWarn)
+
+	expected := []resourceRecord{
+		{Name: "@", Type: A, RData: "10.0.0.5", AddressID: 2},
+		{Name: "*.apps", Type: A, RData: "10.0.0.8", AddressID: 3},
+		{Name: "api.apps", Type: A, RData: "10.0.0.9", AddressID: 4},
+		{Name: "apps", Type: A, RData: "10.0.0.10", AddressID: 5},
+	}
+	if diff := deep.Equal(expected, resolved); diff != nil {
+		t.Error(diff)
+	}
+
+	expectedConflicts := []recordConflict{
+		{Zone: "example.com", Name: "@", Kind: conflictCNameAtApex, Action: actionSkipped, Records: []conflictRecord{
+			{Type: CName, RData: "web", AddressID: 1, Skipped: true},
+		}},
+		{Zone: "example.com", Name: "api.apps", Kind: conflictWildcardShadowed, Action: actionAllowed, Records: []conflictRecord{
+			{Type: A, RData: "10.0.0.8", AddressID: 3},
+			{Type: A, RData: "10.0.0.9", AddressID: 4},
+		}},
+	}
+	if diff := deep.Equal(expectedConflicts, conflicts); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestConflictErrorIgnoresAllowed(t *testing.T) {
+	zones := []Zone{{Name: "example.com", Records: []resourceRecord{
+		{Name: "*.apps", Type: A, RData: "10.0.0.8", AddressID: 3},
+		{Name: "api.apps", Type: A, RData: "10.0.0.9", AddressID: 4},
+	}}}
+
+	conflicts, err := resolveConflicts(zones, conflictError)
+	if err != nil {
+		t.Errorf("Expected shadowed wildcards not to fail the generation; but got %v", err)
+	}
+	if len(conflicts) != 1 || conflicts[0].Kind != conflictWildcardShadowed {
+		t.Errorf("Expected the shadowed wildcard to be reported; but got %v", conflicts)
+	}
+}
@@ -0,0 +1,268 @@
+package dns
+
+import (
+	"net"
+	"sort"
+	"strings"
+
+	"peg.nu/nx/config"
+	"peg.nu/nx/model"
+	"peg.nu/nx/tagparser"
+	"peg.nu/nx/util"
+)
+
+type delegationNameserver struct {
+	Name string
+	IPs  []string
+}
+
+type delegation struct {
+	Zone        string
+	Nameservers []delegationNameserver
+	DSRecords   []string
+}
+
+func normalizeZoneName(zone string) string {
+	return strings.TrimRight(strings.ToLower(strings.TrimSpace(zone)), ".")
+}
+
+// isSubdomain returns true if name is equal to or below zone
+func isSubdomain(name, zone string) bool {
+	return name == zone || strings.HasSuffix(name, "."+zone)
+}
+
+// apexName is the relative name of the zone apex
+const apexName = "@"
+
+// relativeName returns name relative to zone, "@" being the zone apex
+func relativeName(name, zone string) string {
+	if name == zone {
+		return apexName
+	}
+
+	return strings.TrimSuffix(name, "."+zone)
+}
+
+// absoluteName returns the relative name in zone as fully qualified name without the trailing dot
+func absoluteName(name, zone string) string {
+	if name == apexName {
+		return zone
+	}
+	if strings.HasSuffix(name, ".") {
+		return strings.TrimSuffix(name, ".")
+	}
+
+	return name + "." + zone
+}
+
+// delegationTags are the delegate tags of a single address
+type delegationTags struct {
+	Delegations []string `nx:"delegate,ns:dns"`
+}
+
+// addressDelegations returns the zones delegated to the address. Unlike the other tags, the delegate tag is not
+// inherited from the prefix, as that would make every address of the prefix a nameserver of the delegated zone.
+func addressDelegations(address *model.IPAddress) []string {
+	var tags delegationTags
+	tagparser.ParseTags(&tags, address.Tags, nil)
+
+	return tags.Delegations
+}
+
+// prefixDelegations returns the zones delegated by the prefix of the address. The prefix only declares the delegation,
+// its nameservers are taken from the config or from addresses tagged with the delegated zone.
+func prefixDelegations(address *model.IPAddress) []string {
+	var tags delegationTags
+	tagparser.ParseTags(&tags, address.Prefix.Tags, nil)
+
+	return tags.Delegations
+}
+
+// configuredZones returns the zones of all configured primaries
+func configuredZones(conf *config.NXConfig) []string {
+	var zones []string
+	for _, primary := range conf.Namespaces.DNS.Primaries {
+		for _, zone := range primary.Zones {
+			zones = append(zones, normalizeZoneName(zone))
+		}
+	}
+
+	return zones
+}
+
+// findEnclosingZone returns the most specific zone that name is the apex or a subdomain of
+func findEnclosingZone(name string, zones []string) (string, bool) {
+	if util.SliceContainsString(zones, name) {
+		return name, true
+	}
+
+	return findParentZone(name, zones)
+}
+
+// findParentZone returns the most specific zone that name is a real subdomain of
+func findParentZone(name string, zones []string) (string, bool) {
+	parent := ""
+	for _, zone := range zones {
+		if name == zone || !isSubdomain(name, zone) {
+			continue
+		}
+		if len(zone) > len(parent) {
+			parent = zone
+		}
+	}
+
+	return parent, len(parent) > 0
+}
+
+func putNameserver(theMap map[string][]delegationNameserver, zone string, nameserver delegationNameserver) {
+	zone = normalizeZoneName(zone)
+	nameserver.Name = normalizeZoneName(nameserver.Name)
+
+	for i, existing := range theMap[zone] {
+		if existing.Name == nameserver.Name {
+			theMap[zone][i].IPs = append(append([]string{}, existing.IPs...), nameserver.IPs...)
+			return
+		}
+	}
+
+	theMap[zone] = append(theMap[zone], nameserver)
+}
+
+// collectDelegations merges the delegations from the config with the ones tagged on NetBox prefixes and addresses
+func collectDelegations(conf *config.NXConfig, taggedNameservers map[string][]delegationNameserver) []delegation {
+	var delegations []delegation
+	nameservers := make(map[string][]delegationNameserver)
+	delegationIndex := make(map[string]int)
+
+	addDelegation := func(zone string) int {
+		idx, ok := delegationIndex[zone]
+		if !ok {
+			idx = len(delegations)
+			delegationIndex[zone] = idx
+			delegations = append(delegations, delegation{Zone: zone})
+		}
+		return idx
+	}
+
+	for _, primary := range conf.Namespaces.DNS.Primaries {
+		for _, configured := range primary.Delegations {
+			zone := normalizeZoneName(configured.Zone)
+			idx := addDelegation(zone)
+
+			for _, nameserver := range configured.Nameservers {
+				putNameserver(nameservers, zone, delegationNameserver{Name: nameserver.Name, IPs: nameserver.IPs})
+			}
+			delegations[idx].DSRecords = append(delegations[idx].DSRecords, configured.DSRecords...)
+		}
+	}
+
+	taggedZones := make([]string, 0, len(taggedNameservers))
+	for zone := range taggedNameservers {
+		taggedZones = append(taggedZones, zone)
+	}
+	sort.Strings(taggedZones)
+
+	for _, zone := range taggedZones {
+		addDelegation(zone)
+		for _, nameserver := range taggedNameservers[zone] {
+			putNameserver(nameservers, zone, nameserver)
+		}
+	}
+
+	for i := range delegations {
+		delegations[i].Nameservers = nameservers[delegations[i].Zone]
+	}
+
+	return delegations
+}
+
+func containsRecord(records []resourceRecord, record resourceRecord) bool {
+	for _, existing := range records {
+		if existing.sameData(record) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// addDelegationRecords adds the NS, glue and DS records of the delegations to their parent zones
+func addDelegationRecords(zoneRecordsMap map[string][]resourceRecord, delegations []delegation, zones []string) {
+	for _, d := range delegations {
+		parent, ok := findParentZone(d.Zone, zones)
+		if !ok {
+			logger.Printf("Could not find a configured parent zone for delegated zone %s, skipping", d.Zone)
+			continue
+		}
+		if len(d.Nameservers) == 0 {
+			logger.Printf("Delegated zone %s has no nameservers in the config or on tagged addresses, skipping", d.Zone)
+			continue
+		}
+
+		name := relativeName(d.Zone, parent)
+		warnHiddenRecords(zoneRecordsMap[parent], parent, d)
+
+		putDelegationRecord := func(record resourceRecord) {
+			if !containsRecord(zoneRecordsMap[parent], record) {
+				putMap(zoneRecordsMap, parent, record)
+			}
+		}
+
+		for _, nameserver := range d.Nameservers {
+			putDelegationRecord(resourceRecord{Name: name, Type: NS, RData: nameserver.Name + "."})
+
+			// glue is only required for nameservers within the delegated zone
+			if !isSubdomain(nameserver.Name, d.Zone) {
+				continue
+			}
+			if len(nameserver.IPs) == 0 {
+				logger.Printf("Nameserver %s of delegated zone %s is within the zone but has no glue ip, it can not be resolved", nameserver.Name, d.Zone)
+			}
+			for _, nsIP := range nameserver.IPs {
+				ip := net.ParseIP(nsIP)
+				if ip == nil {
+					logger.Printf("Could not parse glue ip <%s> of nameserver %s for zone %s", nsIP, nameserver.Name, d.Zone)
+					continue
+				}
+
+				glueType := Aaaa
+				if ip.To4() != nil {
+					glueType = A
+				}
+				putDelegationRecord(resourceRecord{Name: relativeName(nameserver.Name, parent), Type: glueType, RData: ip.String()})
+			}
+		}
+
+		for _, ds := range d.DSRecords {
+			putDelegationRecord(resourceRecord{Name: name, Type: DS, RData: strings.TrimSpace(ds)})
+		}
+
+		logger.Printf("Delegated %s from %s to %d nameserver(s)\n", d.Zone, parent, len(d.Nameservers))
+	}
+}
+
+// warnHiddenRecords logs the records of the parent zone at or below the zone cut of the delegation, which are hidden
+// by it. The addresses of the nameservers of the delegation are kept as glue.
+func warnHiddenRecords(records []resourceRecord, parent string, d delegation) {
+	for _, record := range records {
+		owner := absoluteName(record.Name, parent)
+		if !isSubdomain(owner, d.Zone) || isGlueRecord(record, owner, d) {
+			continue
+		}
+
+		logger.Printf("Record %s %s %s of zone %s is below the delegation of %s and hidden by it", record.Name, record.Type, record.RData, parent, d.Zone)
+	}
+}
+
+func isGlueRecord(record resourceRecord, owner string, d delegation) bool {
+	if record.Type != A && record.Type != Aaaa {
+		return false
+	}
+	for _, nameserver := range d.Nameservers {
+		if nameserver.Name == owner {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,123 @@
+package dns
+
+import (
+	"peg.nu/nx/config"
+	"peg.nu/nx/model"
+	"testing"
+
+	"github.com/go-test/deep"
+)
+
+func TestDelegationRecords(t *testing.T) {
+	conf := &config.NXConfig{Namespaces: config.NamespaceConfig{DNS: config.DNSNamespaceConfig{Primaries: []config.PrimaryConfig{{
+		Zones: []string{"example.com", "bue39.example.com"},
+		Delegations: []config.ZoneDelegation{{
+			Zone: "lab.bue39.example.com.",
+			Nameservers: []config.DelegationNameserver{
+				{Name: "ns1.lab.bue39.example.com", IPs: []string{"192.0.2.53", "2001:db8::53"}},
+				{Name: "ns.example.net"},
+			},
+			DSRecords: []string{"12345 13 2 ABCDEF"},
+		}},
+	}}}}}
+	tagged := map[string][]delegationNameserver{}
+	putNameserver(tagged, "lab.bue39.example.com", delegationNameserver{Name: "ns.example.net", IPs: []string{"192.0.2.1"}})
+	putNameserver(tagged, "other.example.com", delegationNameserver{Name: "ns1.other.example.com", IPs: []string{"192.0.2.2"}})
+
+	zoneRecordsMap := map[string][]resourceRecord{}
+	addDelegationRecords(zoneRecordsMap, collectDelegations(conf, tagged), configuredZones(conf))
+
+	expected := map[string][]resourceRecord{
+		"bue39.example.com": {
+			{Name: "lab", Type: NS, RData: "ns1.lab.bue39.example.com."},
+			{Name: "ns1.lab", Type: A, RData: "192.0.2.53"},
+			{Name: "ns1.lab", Type: Aaaa, RData: "2001:db8::53"},
+			{Name: "lab", Type: NS, RData: "ns.example.net."},
+			{Name: "lab", Type: DS, RData: "12345 13 2 ABCDEF"},
+		},
+		"example.com": {
+			{Name: "other", Type: NS, RData: "ns1.other.example.com."},
+			{Name: "ns1.other", Type: A, RData: "192.0.2.2"},
+		},
+	}
+	if diff := deep.Equal(expected, zoneRecordsMap); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestCollectZoneRecordsDelegateTag(t *testing.T) {
+	conf := &config.NXConfig{Namespaces: config.NamespaceConfig{DNS: config.DNSNamespaceConfig{Primaries: []config.PrimaryConfig{{
+		Zones: []string{"example.com"},
+		Delegations: []config.ZoneDelegation{{
+			Zone:        "dev.example.com",
+			Nameservers: []config.DelegationNameserver{{Name: "ns.example.net"}},
+		}},
+	}}}}}
+	labPrefix := model.IPAMPrefix{Prefix: "192.0.2.0/24", Tags: []model.Tag{
+		{Name: "nx:dns:enable[true]"}, {Name: "nx:dns:forward_zone[example.com]"}, {Name: "nx:dns:delegate[lab.example.com]"},
+	}}
+	devPrefix := model.IPAMPrefix{Prefix: "198.51.100.0/24", Tags: []model.Tag{
+		{Name: "nx:dns:enable[true]"}, {Name: "nx:dns:forward_zone[example.com]"}, {Name: "nx:dns:delegate[dev.example.com]"},
+	}}
+	addresses := []model.IPAddress{
+		{ID: 1, Address: "192.0.2.53/24", DnsName: "ns1.lab", Prefix: &labPrefix, Tags: []model.Tag{{Name: "nx:dns:delegate[lab.example.com]"}}},
+		{ID: 2, Address: "192.0.2.80/24", DnsName: "web", Prefix: &labPrefix},
+		{ID: 3, Address: "198.51.100.80/24", DnsName: "build", Prefix: &devPrefix},
+	}
+
+	zoneRecordsMap, _ := collectZoneRecords(addresses, conf)
+
+	// the delegate tag of a prefix declares the delegation, but does not make its addresses nameservers of it
+	expected := []resourceRecord{
+		{Name: "ns1.lab", Type: A, RData: "192.0.2.53", AddressID: 1},
+		{Name: "web", Type: A, RData: "192.0.2.80", AddressID: 2},
+		{Name: "build", Type: A, RData: "198.51.100.80", AddressID: 3},
+		{Name: "dev", Type: NS, RData: "ns.example.net."},
+		{Name: "lab", Type: NS, RData: "ns1.lab.example.com."},
+	}
+	if diff := deep.Equal(zoneRecordsMap["example.com"], expected); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestCollectZoneRecordsPrefixDelegation(t *testing.T) {
+	conf := &config.NXConfig{Namespaces: config.NamespaceConfig{DNS: config.DNSNamespaceConfig{Primaries: []config.PrimaryConfig{{
+		Zones: []string{"example.com"},
+	}}}}}
+	prefix := model.IPAMPrefix{Prefix: "192.0.2.0/24", Tags: []model.Tag{
+		{Name: "nx:dns:enable[true]"}, {Name: "nx:dns:forward_zone[example.com]"}, {Name: "nx:dns:delegate[Lab.example.com.]"},
+	}}
+	addresses := []model.IPAddress{
+		{ID: 1, Address: "192.0.2.53/24", DnsName: "ns1", Prefix: &prefix, Tags: []model.Tag{{Name: "nx:dns:delegate[lab.example.com]"}}},
+	}
+
+	zoneRecordsMap, _ := collectZoneRecords(addresses, conf)
+
+	// the zone of the prefix tag is normalized and merged with the delegation tagged on the address
+	expected := []resourceRecord{
+		{Name: "ns1", Type: A, RData: "192.0.2.53", AddressID: 1},
+		{Name: "lab", Type: NS, RData: "ns1.example.com."},
+	}
+	if diff := deep.Equal(zoneRecordsMap["example.com"], expected); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestIsGlueRecord(t *testing.T) {
+	d := delegation{Zone: "lab.example.com", Nameservers: []delegationNameserver{{Name: "ns1.lab.example.com"}}}
+	tests := []struct {
+		record   resourceRecord
+		expected bool
+	}{
+		{resourceRecord{Name: "ns1.lab", Type: A}, true},
+		{resourceRecord{Name: "ns1.lab", Type: Aaaa}, true},
+		{resourceRecord{Name: "ns1.lab", Type: CName}, false},
+		{resourceRecord{Name: "web.lab", Type: A}, false},
+	}
+
+	for _, test := range tests {
+		if actual := isGlueRecord(test.record, absoluteName(test.record.Name, "example.com"), d); actual != test.expected {
+			t.Errorf("Expected glue <%v> for %v; but was <%v>", test.expected, test.record, actual)
+		}
+	}
+}
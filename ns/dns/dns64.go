@@ -0,0 +1,94 @@
+package dns
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// dns64PrefixLengths are the NAT64 prefix lengths defined in RFC 6052
+var dns64PrefixLengths = []int{32, 40, 48, 56, 64, 96}
+
+// dns64Address embeds the IPv4 address into the NAT64 prefix as defined in RFC 6052
+func dns64Address(prefix string, ip4 net.IP) (net.IP, error) {
+	_, prefixNet, err := net.ParseCIDR(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	ones, bits := prefixNet.Mask.Size()
+	if bits != 128 {
+		return nil, fmt.Errorf("DNS64 prefix %s is not an IPv6 prefix", prefix)
+	}
+	validLength := false
+	for _, length := range dns64PrefixLengths {
+		validLength = validLength || ones == length
+	}
+	if !validLength {
+		return nil, fmt.Errorf("length of DNS64 prefix %s is not one of %v", prefix, dns64PrefixLengths)
+	}
+
+	v4 := ip4.To4()
+	if v4 == nil {
+		return nil, fmt.Errorf("%s is not an IPv4 address", ip4)
+	}
+
+	synthesized := make(net.IP, net.IPv6len)
+	copy(synthesized, prefixNet.IP.To16())
+	pos := ones / 8
+	for _, b := range v4 {
+		// bits 64 to 71 are reserved and have to be zero, the IPv4 address continues after them
+		if pos == 8 {
+			pos++
+		}
+		synthesized[pos] = b
+		pos++
+	}
+
+	return synthesized, nil
+}
+
+// v6AliasPlaceholder is replaced by the first label of the name in v6 alias patterns
+const v6AliasPlaceholder = "{name}"
+
+// isValidV6AliasPattern reports whether the pattern contains the placeholder, without it all hosts would get the same
+// alias
+func isValidV6AliasPattern(pattern string) bool {
+	return strings.Contains(pattern, v6AliasPlaceholder)
+}
+
+// v6AliasName returns the alias of the name, {name} in the pattern is replaced by the first label of the name
+func v6AliasName(pattern, name string) string {
+	labels := strings.SplitN(name, ".", 2)
+	alias := strings.ReplaceAll(pattern, v6AliasPlaceholder, labels[0])
+	if len(labels) == 2 {
+		alias = fmt.Sprintf("%s.%s", alias, labels[1])
+	}
+
+	return alias
+}
+
+// hasAddressRecord reports whether the records contain an address record of the type for the name visible in the view
+func hasAddressRecord(records []resourceRecord, name string, recordType rrType, view string) bool {
+	for _, record := range records {
+		if record.Name != name || record.Type != recordType {
+			continue
+		}
+		if len(record.View) == 0 || len(view) == 0 || record.View == view {
+			return true
+		}
+	}
+
+	return false
+}
+
+// addDNS64Records adds the synthesized AAAA records of all hosts without an IPv6 address of their own
+func addDNS64Records(zoneRecordsMap map[string][]resourceRecord, dns64Records map[string][]resourceRecord) {
+	for zone, records := range dns64Records {
+		for _, record := range records {
+			if !hasAddressRecord(zoneRecordsMap[zone], record.Name, Aaaa, record.View) {
+				putMap(zoneRecordsMap, zone, record)
+			}
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package dns
+
+import (
+	"net"
+	"testing"
+
+	"github.com/go-test/deep"
+	"peg.nu/nx/config"
+	"peg.nu/nx/model"
+)
+
+func TestDNS64Address(t *testing.T) {
+	// examples of RFC 6052 section 2.4
+	tests := map[string]string{
+		"2001:db8::/32":          "2001:db8:c000:221::",
+		"2001:db8:100::/40":      "2001:db8:1c0:2:21::",
+		"2001:db8:122::/48":      "2001:db8:122:c000:2:2100::",
+		"2001:db8:122:300::/56":  "2001:db8:122:3c0:0:221::",
+		"2001:db8:122:344::/64":  "2001:db8:122:344:c0:2:2100:0",
+		"2001:db8:122:344::/96":  "2001:db8:122:344::c000:221",
+		"64:ff9b::/96":           "64:ff9b::c000:221",
+		"2001:db8:122:344::/80":  "",
+		"192.0.2.0/24":           "",
+		"not a prefix":           "",
+		"2001:db8:122:344::1/96": "2001:db8:122:344::c000:221",
+	}
+
+	for prefix, expected := range tests {
+		actual, err := dns64Address(prefix, net.ParseIP("192.0.2.33"))
+		if len(expected) == 0 {
+			if err == nil {
+				t.Errorf("Expected an error for prefix %s; but was <%s>", prefix, actual)
+			}
+			continue
+		}
+		if err != nil || actual.String() != expected {
+			t.Errorf("Expected <%s> for prefix %s; but was <%s> (%v)", expected, prefix, actual, err)
+		}
+	}
+}
+
+func TestV6AliasName(t *testing.T) {
+	tests := map[string]string{"web": "web-v6", "host.dept": "host-v6.dept"}
+	for name, expected := range tests {
+		if actual := v6AliasName("{name}-v6", name); actual != expected {
+			t.Errorf("Expected alias <%s> for <%s>; but was <%s>", expected, name, actual)
+		}
+	}
+}
+
+func TestCollectZoneRecordsDNS64(t *testing.T) {
+	conf := &config.NXConfig{Namespaces: config.NamespaceConfig{DNS: config.DNSNamespaceConfig{Primaries: []config.PrimaryConfig{
+		{Name: "ns1", Zones: []string{"example.com"}},
+	}}}}
+	v4 := model.IPAMPrefix{Prefix: "10.0.0.0/24", Tags: []model.Tag{
+		{Name: "nx:dns:enable[true]"}, {Name: "nx:dns:forward_zone[example.com]"}, {Name: "nx:dns:dns64[64:ff9b::/96]"},
+	}}
+	v6 := model.IPAMPrefix{Prefix: "fd00::/64", Tags: []model.Tag{
+		{Name: "nx:dns:enable[true]"}, {Name: "nx:dns:forward_zone[example.com]"}, {Name: "nx:dns:v6_alias[{name}-v6]"},
+	}}
+	addresses := []model.IPAddress{
+		{ID: 1, Address: "10.0.0.5/24", DnsName: "web", Prefix: &v4},
+		{ID: 2, Address: "10.0.0.6/24", DnsName: "db", Prefix: &v4},
+		{ID: 3, Address: "fd00::6/64", DnsName: "db", Prefix: &v6},
+	}
+
+	zoneRecordsMap, _ := collectZoneRecords(addresses, conf)
+	expected := []resourceRecord{
+		{Name: "web", Type: A, RData: "10.0.0.5", AddressID: 1},
+		{Name: "db", Type: A, RData: "10.0.0.6", AddressID: 2},
+		{Name: "db", Type: Aaaa, RData: "fd00::6", AddressID: 3},
+		{Name: "db-v6", Type: Aaaa, RData: "fd00::6", AddressID: 3},
+		// db has an IPv6 address of its own and gets no synthesized record
+		{Name: "web", Type: Aaaa, RData: "64:ff9b::a00:5", AddressID: 1},
+	}
+	if diff := deep.Equal(zoneRecordsMap["example.com"], expected); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestCollectZoneRecordsV6AliasPatterns(t *testing.T) {
+	conf := &config.NXConfig{Namespaces: config.NamespaceConfig{DNS: config.DNSNamespaceConfig{Primaries: []config.PrimaryConfig{
+		{Name: "ns1", Zones: []string{"example.com"}},
+	}}}}
+	prefix := model.IPAMPrefix{Prefix: "fd00::/64", Tags: []model.Tag{{Name: "nx:dns:enable[true]"}, {Name: "nx:dns:forward_zone[example.com]"}}}
+	addresses := []model.IPAddress{
+		{ID: 1, Address: "fd00::5/64", DnsName: "web", Prefix: &prefix, Tags: []model.Tag{{Name: "nx:dns:v6_alias[v6]"}}},
+		{ID: 2, Address: "fd00::6/64", DnsName: "db", Prefix: &prefix, Tags: []model.Tag{{Name: "nx:dns:v6_alias[{name}_V6]"}}},
+	}
+
+	zoneRecordsMap, sanitizations := collectZoneRecords(addresses, conf)
+
+	// the pattern without {name} is ignored and the alias of db is sanitized like the names of the hosts
+	expected := []resourceRecord{
+		{Name: "web", Type: Aaaa, RData: "fd00::5", AddressID: 1},
+		{Name: "db", Type: Aaaa, RData: "fd00::6", AddressID: 2},
+		{Name: "db-v6", Type: Aaaa, RData: "fd00::6", AddressID: 2},
+	}
+	if diff := deep.Equal(zoneRecordsMap["example.com"], expected); diff != nil {
+		t.Error(diff)
+	}
+	if len(sanitizations) != 1 || sanitizations[0].Original != "db_V6" || sanitizations[0].Sanitized != "db-v6" {
+		t.Errorf("Expected the sanitization of alias db_V6; but was <%v>", sanitizations)
+	}
+}
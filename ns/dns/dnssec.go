@@ -0,0 +1,194 @@
+package dns
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"peg.nu/nx/config"
+	"peg.nu/nx/util"
+)
+
+const defaultDnssecPolicy = "default"
+const defaultDnssecAlgorithm = "ecdsap256sha256"
+const unlimitedKeyLifetime = "unlimited"
+
+// builtinDnssecPolicies are the policies BIND provides without a dnssec-policy statement
+var builtinDnssecPolicies = []string{defaultDnssecPolicy, "insecure", "none"}
+
+// zoneDnssecPolicy returns the name of the DNSSEC policy of the zone and whether the zone is signed. Zones with a
+// policy are signed even if they are not listed in the DNSSEC zones of the primary.
+func zoneDnssecPolicy(primary *config.PrimaryConfig, zone string) (string, bool) {
+	if policy, ok := primary.DnssecZonePolicies[zone]; ok && len(policy) > 0 {
+		return policy, true
+	}
+	if util.SliceContainsString(primary.DnssecZones, zone) {
+		return defaultDnssecPolicy, true
+	}
+
+	return "", false
+}
+
+// checkDnssecSupport fails for signed zones of nameservers which can not sign zones themselves
+func checkDnssecSupport(conf *config.NXConfig) {
+	for _, primary := range conf.Namespaces.DNS.Primaries {
+		if primaryOutput(&primary) != outputFiles || primarySoftware(&primary) != softwareNSD {
+			continue
+		}
+
+		for _, zone := range primary.Zones {
+			if _, signed := zoneDnssecPolicy(&primary, zone); signed {
+				panic(fmt.Errorf("zone %s of nameserver %s is DNSSEC enabled, but NSD can not sign zones; use BIND or Knot DNS for signed zones", zone, primary.Name))
+			}
+		}
+	}
+}
+
+type dnssecPolicyKey struct {
+	Role      string
+	Lifetime  string
+	Algorithm string
+}
+
+type templateDnssecPolicy struct {
+	Name            string
+	Keys            []dnssecPolicyKey
+	NSEC3           bool
+	NSEC3Iterations int
+	NSEC3OptOut     bool
+	NSEC3SaltLength int
+}
+
+func newTemplateDnssecPolicy(policy config.DnssecPolicy) templateDnssecPolicy {
+	algorithm := strings.ToLower(policy.Algorithm)
+	if len(algorithm) == 0 {
+		algorithm = defaultDnssecAlgorithm
+	}
+	lifetime := func(lifetime string) string {
+		if len(lifetime) == 0 {
+			return unlimitedKeyLifetime
+		}
+		return lifetime
+	}
+
+	// a single combined signing key is used unless lifetimes for separate keys are configured
+	keys := []dnssecPolicyKey{{Role: "csk", Lifetime: unlimitedKeyLifetime, Algorithm: algorithm}}
+	if len(policy.KSKLifetime) > 0 || len(policy.ZSKLifetime) > 0 {
+		keys = []dnssecPolicyKey{
+			{Role: "ksk", Lifetime: lifetime(policy.KSKLifetime), Algorithm: algorithm},
+			{Role: "zsk", Lifetime: lifetime(policy.ZSKLifetime), Algorithm: algorithm},
+		}
+	}
+
+	return templateDnssecPolicy{
+		Name:            policy.Name,
+		Keys:            keys,
+		NSEC3:           policy.NSEC3,
+		NSEC3Iterations: policy.NSEC3Iterations,
+		NSEC3OptOut:     policy.NSEC3OptOut,
+		NSEC3SaltLength: policy.NSEC3SaltLength,
+	}
+}
+
+// primaryDnssecPolicies returns the configured policies used by the zones of the primary, ordered by name
+func primaryDnssecPolicies(primary *config.PrimaryConfig, zones []string, conf *config.NXConfig) []templateDnssecPolicy {
+	var names []string
+	for _, zone := range primary.Zones {
+		if !util.SliceContainsString(zones, zone) {
+			continue
+		}
+
+		policy, signed := zoneDnssecPolicy(primary, zone)
+		if signed && !util.SliceContainsString(builtinDnssecPolicies, policy) && !util.SliceContainsString(names, policy) {
+			names = append(names, policy)
+		}
+	}
+	sort.Strings(names)
+
+	var policies []templateDnssecPolicy
+	for _, name := range names {
+		found := false
+		for _, policy := range conf.Namespaces.DNS.DnssecPolicies {
+			if policy.Name == name {
+				policies = append(policies, newTemplateDnssecPolicy(policy))
+				found = true
+				break
+			}
+		}
+		if !found {
+			panic(fmt.Errorf("DNSSEC policy %s used by nameserver %s is not configured", name, primary.Name))
+		}
+	}
+
+	return policies
+}
+
+type dsStatus string
+
+const (
+	// dsPublished means the parent zone is generated by nx and contains DS records for the zone
+	dsPublished dsStatus = "published"
+	// dsMissing means the parent zone is generated by nx but has no DS records for the zone configured
+	dsMissing dsStatus = "missing"
+	// dsExternal means the parent zone is not generated by nx, the DS records have to be published by its operator
+	dsExternal dsStatus = "external"
+)
+
+// dsReportEntry tells where the DS records of a signed zone have to be published
+type dsReportEntry struct {
+	Zone    string   `json:"zone"`
+	Primary string   `json:"primary"`
+	Policy  string   `json:"policy"`
+	Parent  string   `json:"parent"`
+	Status  dsStatus `json:"status"`
+}
+
+// hasDSRecords reports whether any view of the parent zone contains DS records for the zone
+func hasDSRecords(zones []Zone, parent, zone string) bool {
+	name := relativeName(zone, parent)
+	for _, z := range zones {
+		if z.Name != parent {
+			continue
+		}
+		for _, record := range z.Records {
+			if record.Type == DS && record.Name == name {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// dnssecReport lists the signed zones of all primaries together with the state of their DS records in the parent zone
+func dnssecReport(zones []Zone, conf *config.NXConfig) []dsReportEntry {
+	var entries []dsReportEntry
+	configured := configuredZones(conf)
+	for _, primary := range conf.Namespaces.DNS.Primaries {
+		for _, zone := range primary.Zones {
+			policy, signed := zoneDnssecPolicy(&primary, zone)
+			if !signed {
+				continue
+			}
+
+			zone = normalizeZoneName(zone)
+			entry := dsReportEntry{Zone: zone, Primary: primary.Name, Policy: policy, Status: dsExternal}
+			if parent, ok := findParentZone(zone, configured); ok {
+				entry.Parent = parent
+				entry.Status = dsMissing
+				if hasDSRecords(zones, parent, zone) {
+					entry.Status = dsPublished
+				}
+			} else if idx := strings.Index(zone, "."); idx >= 0 {
+				entry.Parent = zone[idx+1:]
+			}
+
+			if entry.Status == dsMissing {
+				logger.Printf("DS records of signed zone %s are missing in parent zone %s, add them to its delegation", zone, entry.Parent)
+			}
+			entries = append(entries, entry)
+		}
+	}
+
+	return entries
+}
@@ -0,0 +1,103 @@
+package dns
+
+import (
+	"testing"
+
+	"github.com/go-test/deep"
+	"peg.nu/nx/config"
+)
+
+var dnssecTestConf = &config.NXConfig{Namespaces: config.NamespaceConfig{DNS: config.DNSNamespaceConfig{
+	DnssecPolicies: []config.DnssecPolicy{
+		{Name: "strict", Algorithm: "ECDSAP384SHA384", KSKLifetime: "P1Y", ZSKLifetime: "P90D", NSEC3: true},
+		{Name: "simple"},
+	},
+	Primaries: []config.PrimaryConfig{
+		{
+			Name:               "ns1.example.com",
+			Zones:              []string{"example.com", "lab.example.com", "168.192.in-addr.arpa"},
+			DnssecZones:        []string{"168.192.in-addr.arpa"},
+			DnssecZonePolicies: map[string]string{"example.com": "strict", "lab.example.com": "simple"},
+		},
+		{
+			Name:               "ns2.example.com",
+			Zones:              []string{"dev.example.com", "example.net"},
+			DnssecZonePolicies: map[string]string{"dev.example.com": "unknown"},
+		},
+	},
+}}}
+
+func TestZoneDnssecPolicy(t *testing.T) {
+	tests := map[string]string{"example.com": "strict", "168.192.in-addr.arpa": defaultDnssecPolicy, "example.org": ""}
+	for zone, expected := range tests {
+		policy, signed := zoneDnssecPolicy(&dnssecTestConf.Namespaces.DNS.Primaries[0], zone)
+		if policy != expected || signed != (len(expected) > 0) {
+			t.Errorf("Expected policy <%s> for zone %s; but was <%s> (signed: %v)", expected, zone, policy, signed)
+		}
+	}
+}
+
+func TestPrimaryDnssecPolicies(t *testing.T) {
+	expected := []templateDnssecPolicy{
+		{Name: "simple", Keys: []dnssecPolicyKey{{Role: "csk", Lifetime: unlimitedKeyLifetime, Algorithm: defaultDnssecAlgorithm}}},
+		{Name: "strict", NSEC3: true, Keys: []dnssecPolicyKey{
+			{Role: "ksk", Lifetime: "P1Y", Algorithm: "ecdsap384sha384"},
+			{Role: "zsk", Lifetime: "P90D", Algorithm: "ecdsap384sha384"},
+		}},
+	}
+
+	primary := &dnssecTestConf.Namespaces.DNS.Primaries[0]
+	if diff := deep.Equal(primaryDnssecPolicies(primary, primary.Zones, dnssecTestConf), expected); diff != nil {
+		t.Error(diff)
+	}
+
+	// policies of zones which are not generated are not needed
+	if policies := primaryDnssecPolicies(primary, []string{"168.192.in-addr.arpa"}, dnssecTestConf); len(policies) != 0 {
+		t.Errorf("Expected no policies; but was <%v>", policies)
+	}
+}
+
+func TestPrimaryDnssecPoliciesUnknown(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected a panic for the unknown policy")
+		}
+	}()
+
+	primary := &dnssecTestConf.Namespaces.DNS.Primaries[1]
+	primaryDnssecPolicies(primary, primary.Zones, dnssecTestConf)
+}
+
+func TestDnssecReport(t *testing.T) {
+	zones := []Zone{
+		{Name: "example.com", Records: []resourceRecord{{Name: "lab", Type: DS, RData: "12345 13 2 ABCDEF"}}},
+	}
+
+	expected := []dsReportEntry{
+		{Zone: "example.com", Primary: "ns1.example.com", Policy: "strict", Parent: "com", Status: dsExternal},
+		{Zone: "lab.example.com", Primary: "ns1.example.com", Policy: "simple", Parent: "example.com", Status: dsPublished},
+		{Zone: "168.192.in-addr.arpa", Primary: "ns1.example.com", Policy: defaultDnssecPolicy, Parent: "192.in-addr.arpa", Status: dsExternal},
+		{Zone: "dev.example.com", Primary: "ns2.example.com", Policy: "unknown", Parent: "example.com", Status: dsMissing},
+	}
+
+	if diff := deep.Equal(dnssecReport(zones, dnssecTestConf), expected); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestCheckDnssecSupport(t *testing.T) {
+	conf := &config.NXConfig{Namespaces: config.NamespaceConfig{DNS: config.DNSNamespaceConfig{Primaries: []config.PrimaryConfig{
+		{Name: "ns1.example.com", Software: "knot", Zones: []string{"example.com"}, DnssecZones: []string{"example.com"}},
+		{Name: "ns2.example.com", Software: "nsd", Zones: []string{"example.net"}},
+	}}}}
+	checkDnssecSupport(conf)
+
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected a panic for the signed zone of the NSD nameserver")
+		}
+	}()
+
+	conf.Namespaces.DNS.Primaries[1].DnssecZonePolicies = map[string]string{"example.net": "strict"}
+	checkDnssecSupport(conf)
+}
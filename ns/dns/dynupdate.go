@@ -0,0 +1,298 @@
+package dns
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	mdns "github.com/miekg/dns"
+	"peg.nu/nx/config"
+	"peg.nu/nx/util"
+)
+
+type outputMode string
+
+const (
+	// outputFiles writes BIND zone files and configs
+	outputFiles outputMode = "files"
+	// outputDynamicUpdate sends the changes to the primary as RFC 2136 dynamic updates
+	outputDynamicUpdate outputMode = "dynamic_update"
+)
+
+func primaryOutput(primary *config.PrimaryConfig) outputMode {
+	if primary == nil || len(primary.Output) == 0 {
+		return outputFiles
+	}
+
+	return outputMode(primary.Output)
+}
+
+func zoneOutput(zone string, conf *config.NXConfig) outputMode {
+	return primaryOutput(util.FindPrimaryForZone(*conf, zone))
+}
+
+// maxUpdateChanges limits the number of changes per UPDATE message to stay below the maximum message size
+const maxUpdateChanges = 500
+
+// managedTypes are the record types nx manages in dynamically updated zones, all other records are left untouched
+var managedTypes = []uint16{mdns.TypeA, mdns.TypeAAAA, mdns.TypeCNAME, mdns.TypePTR, mdns.TypeNS, mdns.TypeDS}
+
+type dynamicUpdater struct {
+	server string
+	key    config.TSIGKey
+	client *mdns.Client
+}
+
+func newDynamicUpdater(primary *config.PrimaryConfig) dynamicUpdater {
+	server := primary.DynamicUpdate.Server
+	if len(server) == 0 {
+		port := primary.Port
+		if port == 0 {
+			port = 53
+		}
+		server = net.JoinHostPort(primary.IP, strconv.Itoa(port))
+	}
+
+	client := &mdns.Client{Net: "tcp", Timeout: 10 * time.Second}
+	if len(primary.DynamicUpdate.TSIG.Name) > 0 {
+		client.TsigSecret = map[string]string{mdns.Fqdn(primary.DynamicUpdate.TSIG.Name): primary.DynamicUpdate.TSIG.Secret}
+	}
+
+	return dynamicUpdater{server: server, key: primary.DynamicUpdate.TSIG, client: client}
+}
+
+func (u dynamicUpdater) sign(m *mdns.Msg) {
+	if len(u.key.Name) == 0 {
+		return
+	}
+
+	m.SetTsig(mdns.Fqdn(u.key.Name), tsigAlgorithm(u.key.Algorithm), 300, time.Now().Unix())
+}
+
+// tsigAlgorithm returns the fully qualified TSIG algorithm name, hmac-sha256 by default
+func tsigAlgorithm(algorithm string) string {
+	if len(algorithm) == 0 {
+		return mdns.HmacSHA256
+	}
+
+	return mdns.Fqdn(strings.ToLower(algorithm))
+}
+
+// fetchZone returns the current records of the zone using AXFR
+func (u dynamicUpdater) fetchZone(zone string) ([]mdns.RR, error) {
+	m := new(mdns.Msg)
+	m.SetAxfr(mdns.Fqdn(zone))
+	u.sign(m)
+
+	transfer := &mdns.Transfer{TsigSecret: u.client.TsigSecret}
+	envelopes, err := transfer.In(m, u.server)
+	if err != nil {
+		return nil, err
+	}
+
+	var records []mdns.RR
+	for envelope := range envelopes {
+		if envelope.Error != nil {
+			return nil, envelope.Error
+		}
+		records = append(records, envelope.RR...)
+	}
+
+	return records, nil
+}
+
+// sendUpdate sends the removals and insertions as UPDATE messages. The changes are split into several messages, which are
+// not applied atomically, so the error of a failed message reports how many changes were applied before.
+func (u dynamicUpdater) sendUpdate(zone string, remove, insert []mdns.RR) error {
+	changes := make([]func(m *mdns.Msg), 0, len(remove)+len(insert))
+	for _, rr := range remove {
+		changes = append(changes, func(m *mdns.Msg) { m.Remove([]mdns.RR{rr}) })
+	}
+	for _, rr := range insert {
+		changes = append(changes, func(m *mdns.Msg) { m.Insert([]mdns.RR{rr}) })
+	}
+
+	for start := 0; start < len(changes); start += maxUpdateChanges {
+		end := start + maxUpdateChanges
+		if end > len(changes) {
+			end = len(changes)
+		}
+
+		m := new(mdns.Msg)
+		m.SetUpdate(mdns.Fqdn(zone))
+		for _, change := range changes[start:end] {
+			change(m)
+		}
+		u.sign(m)
+
+		response, _, err := u.client.Exchange(m, u.server)
+		if err == nil && response.Rcode != mdns.RcodeSuccess {
+			err = fmt.Errorf("update was refused: %s", mdns.RcodeToString[response.Rcode])
+		}
+		if err != nil {
+			if start > 0 {
+				return fmt.Errorf("zone %s is partially updated, %d of %d change(s) were applied: %w", zone, start, len(changes), err)
+			}
+			return fmt.Errorf("update of zone %s failed: %w", zone, err)
+		}
+	}
+
+	return nil
+}
+
+func isManaged(rr mdns.RR, zone string) bool {
+	header := rr.Header()
+	// the apex NS records belong to the nameserver, not to nx
+	if header.Rrtype == mdns.TypeNS && strings.EqualFold(header.Name, mdns.Fqdn(zone)) {
+		return false
+	}
+
+	for _, managedType := range managedTypes {
+		if header.Rrtype == managedType {
+			return true
+		}
+	}
+	return false
+}
+
+// zoneRRs converts the records of the zone to resource records with the default TTL
+func zoneRRs(zone Zone) ([]mdns.RR, error) {
+	var text strings.Builder
+	for _, record := range zone.Records {
+		text.WriteString(fmt.Sprintf("%s %d IN %s %s\n", record.Name, zone.SOAInfo.BindDefaultRRTTL, record.Type, record.RData))
+	}
+
+	parser := mdns.NewZoneParser(strings.NewReader(text.String()), mdns.Fqdn(zone.Name), zone.Name)
+	var rrs []mdns.RR
+	for rr, ok := parser.Next(); ok; rr, ok = parser.Next() {
+		rrs = append(rrs, rr)
+	}
+
+	return rrs, parser.Err()
+}
+
+// rrDataKey identifies the record by its name, type and data, ignoring the TTL
+func rrDataKey(rr mdns.RR) string {
+	rr = mdns.Copy(rr)
+	rr.Header().Ttl = 0
+	return strings.ToLower(rr.String())
+}
+
+// diffRRs returns the managed records to remove from current and to insert to reach desired. Only records nx owns, which
+// are the desired and the previously generated ones, are removed, records added by other clients are left untouched.
+// Records with a changed TTL are removed and inserted again.
+func diffRRs(zone string, current, desired, previous []mdns.RR) (remove, insert []mdns.RR) {
+	key := func(rr mdns.RR) string {
+		return strings.ToLower(rr.String())
+	}
+
+	desiredKeys := make(map[string]bool)
+	ownedKeys := make(map[string]bool)
+	for _, rr := range desired {
+		desiredKeys[key(rr)] = true
+		ownedKeys[rrDataKey(rr)] = true
+	}
+	for _, rr := range previous {
+		ownedKeys[rrDataKey(rr)] = true
+	}
+
+	currentKeys := make(map[string]bool)
+	for _, rr := range current {
+		if !isManaged(rr, zone) || !ownedKeys[rrDataKey(rr)] {
+			continue
+		}
+
+		currentKeys[key(rr)] = true
+		if !desiredKeys[key(rr)] {
+			remove = append(remove, rr)
+		}
+	}
+
+	for _, rr := range desired {
+		if !currentKeys[key(rr)] {
+			insert = append(insert, rr)
+			currentKeys[key(rr)] = true
+		}
+	}
+
+	return remove, insert
+}
+
+// pushZone updates the zone on the server to match the generated zone. previous are the records generated for the zone by
+// the previous run, which are removed if they are no longer generated.
+func (u dynamicUpdater) pushZone(zone Zone, previous []resourceRecord) (removed, inserted int, err error) {
+	desired, err := zoneRRs(zone)
+	if err != nil {
+		return 0, 0, err
+	}
+	previousRRs, err := zoneRRs(Zone{Name: zone.Name, SOAInfo: zone.SOAInfo, Records: previous})
+	if err != nil {
+		return 0, 0, err
+	}
+
+	current, err := u.fetchZone(zone.Name)
+	if err != nil {
+		return 0, 0, fmt.Errorf("could not transfer zone %s from %s: %w", zone.Name, u.server, err)
+	}
+
+	remove, insert := diffRRs(zone.Name, current, desired, previousRRs)
+	if len(remove) == 0 && len(insert) == 0 {
+		return 0, 0, nil
+	}
+
+	return len(remove), len(insert), u.sendUpdate(zone.Name, remove, insert)
+}
+
+// previousZoneRecords returns the records of the zone stored by the previous run
+func previousZoneRecords(previous []storedZone, zone Zone) []resourceRecord {
+	for _, stored := range previous {
+		if stored.Zone == zone.Name && stored.View == zone.View {
+			return stored.Records
+		}
+	}
+
+	return nil
+}
+
+// PushDynamicUpdates sends the difference between the generated zones and their current contents on the nameserver as
+// RFC 2136 dynamic updates for all primaries with the dynamic update output
+func PushDynamicUpdates(zones []Zone, conf *config.NXConfig) error {
+	var errs []error
+	var previous []storedZone
+	var previousLoaded bool
+	for _, zone := range zones {
+		primary := util.FindPrimaryForZone(*conf, zone.Name)
+		if primaryOutput(primary) != outputDynamicUpdate {
+			continue
+		}
+		if len(zone.View) > 0 {
+			logger.Printf("Dynamic updates are not supported for views, skipping zone %s in view %s", zone.Name, zone.View)
+			continue
+		}
+		if len(zone.Includes) > 0 {
+			logger.Printf("Includes of zone %s are ignored for dynamic updates", zone.Name)
+		}
+
+		if !previousLoaded {
+			var ok bool
+			previous, ok = readRecordsState()
+			if !ok {
+				logger.Printf("No records of a previous run found, records no longer generated by nx are not removed")
+			}
+			previousLoaded = true
+		}
+
+		updater := newDynamicUpdater(primary)
+		removed, inserted, err := updater.pushZone(zone, previousZoneRecords(previous, zone))
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		logger.Printf("Updated zone %s on %s: %d record(s) removed, %d record(s) added\n", zone.Name, updater.server, removed, inserted)
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,206 @@
+package dns
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	mdns "github.com/miekg/dns"
+	"peg.nu/nx/config"
+)
+
+const testKeyName = "nx-test."
+const testKeySecret = "c2VjcmV0LXNlY3JldC1zZWNyZXQtc2VjcmV0LXNlY3JldA=="
+
+// testZoneServer is an in-process primary supporting TSIG signed AXFR and UPDATE for a single zone
+type testZoneServer struct {
+	mu      sync.Mutex
+	zone    string
+	records []mdns.RR
+	updates int
+	// refuseAfter refuses all updates after the given number of updates if it is set
+	refuseAfter int
+}
+
+func (s *testZoneServer) ServeDNS(w mdns.ResponseWriter, r *mdns.Msg) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if r.IsTsig() == nil || w.TsigStatus() != nil {
+		m := new(mdns.Msg)
+		m.SetRcode(r, mdns.RcodeNotAuth)
+		_ = w.WriteMsg(m)
+		return
+	}
+
+	if r.Opcode == mdns.OpcodeUpdate {
+		m := new(mdns.Msg)
+		m.SetReply(r)
+		if s.refuseAfter > 0 && s.updates >= s.refuseAfter {
+			m.Rcode = mdns.RcodeRefused
+		} else {
+			s.applyUpdate(r)
+		}
+		m.SetTsig(testKeyName, mdns.HmacSHA256, 300, int64(r.IsTsig().TimeSigned))
+		_ = w.WriteMsg(m)
+		return
+	}
+
+	soa, _ := mdns.NewRR(s.zone + " 3600 IN SOA ns1." + s.zone + " admin." + s.zone + " 1 900 900 172800 600")
+	ch := make(chan *mdns.Envelope)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		_ = new(mdns.Transfer).Out(w, r, ch)
+		wg.Done()
+	}()
+	ch <- &mdns.Envelope{RR: append(append([]mdns.RR{soa}, s.records...), soa)}
+	close(ch)
+	wg.Wait()
+}
+
+func (s *testZoneServer) applyUpdate(r *mdns.Msg) {
+	s.updates++
+	for _, rr := range r.Ns {
+		if rr.Header().Class == mdns.ClassNONE {
+			toRemove := mdns.Copy(rr)
+			toRemove.Header().Class = mdns.ClassINET
+			var kept []mdns.RR
+			for _, existing := range s.records {
+				if !mdns.IsDuplicate(existing, toRemove) {
+					kept = append(kept, existing)
+				}
+			}
+			s.records = kept
+			continue
+		}
+		s.records = append(s.records, rr)
+	}
+}
+
+func (s *testZoneServer) recordStrings() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var strs []string
+	for _, rr := range s.records {
+		strs = append(strs, strings.ReplaceAll(rr.String(), "\t", " "))
+	}
+	sort.Strings(strs)
+	return strs
+}
+
+// acceptUpdates accepts UPDATE messages in addition to the queries accepted by default
+func acceptUpdates(header mdns.Header) mdns.MsgAcceptAction {
+	if int(header.Bits>>11)&0xF == mdns.OpcodeUpdate {
+		return mdns.MsgAccept
+	}
+	return mdns.DefaultMsgAcceptFunc(header)
+}
+
+func startTestZoneServer(t *testing.T, zone string, records []string) (*testZoneServer, string) {
+	handler := &testZoneServer{zone: zone}
+	for _, record := range records {
+		rr, err := mdns.NewRR(record)
+		if err != nil {
+			t.Fatal(err)
+		}
+		handler.records = append(handler.records, rr)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	started := make(chan struct{})
+	server := &mdns.Server{Listener: listener, Handler: handler, TsigSecret: map[string]string{testKeyName: testKeySecret}, NotifyStartedFunc: func() { close(started) }, MsgAcceptFunc: acceptUpdates}
+	go func() { _ = server.ActivateAndServe() }()
+	<-started
+	t.Cleanup(func() { _ = server.Shutdown() })
+
+	return handler, listener.Addr().String()
+}
+
+func TestDynamicUpdate(t *testing.T) {
+	handler, address := startTestZoneServer(t, "example.com.", []string{
+		"example.com. 3600 IN NS ns1.example.com.",
+		"web.example.com. 120 IN A 10.0.0.5",
+		"old.example.com. 120 IN A 10.0.0.9",
+		"db.example.com. 60 IN A 10.0.0.6",
+		"laptop.example.com. 120 IN A 10.0.0.50",
+		"example.com. 120 IN TXT \"v=spf1 -all\"",
+	})
+
+	primary := &config.PrimaryConfig{
+		Output:        string(outputDynamicUpdate),
+		DynamicUpdate: config.DynamicUpdateConfig{Server: address, TSIG: config.TSIGKey{Name: "nx-test", Secret: testKeySecret}},
+	}
+	zone := Zone{Name: "example.com", SOAInfo: SOAInfo{BindDefaultRRTTL: 120}, Records: []resourceRecord{
+		{Name: "web", Type: A, RData: "10.0.0.5"},
+		{Name: "www", Type: CName, RData: "web"},
+		{Name: "db", Type: A, RData: "10.0.0.6"},
+		{Name: "5.0.0.10.in-addr.arpa.", Type: Ptr, RData: "web.example.com."},
+	}}
+
+	// old was generated by the previous run, laptop was added by another client and is kept
+	previous := []resourceRecord{{Name: "old", Type: A, RData: "10.0.0.9"}, {Name: "web", Type: A, RData: "10.0.0.5"}}
+
+	removed, inserted, err := newDynamicUpdater(primary).pushZone(zone, previous)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != 2 || inserted != 3 {
+		t.Errorf("Expected 2 removals and 3 insertions; but got %d and %d", removed, inserted)
+	}
+
+	expected := []string{
+		"5.0.0.10.in-addr.arpa. 120 IN PTR web.example.com.",
+		"db.example.com. 120 IN A 10.0.0.6",
+		"example.com. 120 IN TXT \"v=spf1 -all\"",
+		"example.com. 3600 IN NS ns1.example.com.",
+		"laptop.example.com. 120 IN A 10.0.0.50",
+		"web.example.com. 120 IN A 10.0.0.5",
+		"www.example.com. 120 IN CNAME web.example.com.",
+	}
+	if actual := handler.recordStrings(); strings.Join(actual, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Expected records\n%s\nbut got\n%s", strings.Join(expected, "\n"), strings.Join(actual, "\n"))
+	}
+
+	updates := handler.updates
+	removed, inserted, err = newDynamicUpdater(primary).pushZone(zone, zone.Records)
+	if err != nil || removed != 0 || inserted != 0 || handler.updates != updates {
+		t.Errorf("Expected no update for an unchanged zone; but got %d removals, %d insertions, err %v", removed, inserted, err)
+	}
+}
+
+func TestDynamicUpdateWithoutKey(t *testing.T) {
+	_, address := startTestZoneServer(t, "example.com.", nil)
+
+	primary := &config.PrimaryConfig{DynamicUpdate: config.DynamicUpdateConfig{Server: address}}
+	_, _, err := newDynamicUpdater(primary).pushZone(Zone{Name: "example.com"}, nil)
+	if err == nil {
+		t.Errorf("Expected unsigned transfer to be refused")
+	}
+}
+
+func TestDynamicUpdatePartiallyApplied(t *testing.T) {
+	handler, address := startTestZoneServer(t, "example.com.", nil)
+	handler.refuseAfter = 1
+
+	primary := &config.PrimaryConfig{
+		DynamicUpdate: config.DynamicUpdateConfig{Server: address, TSIG: config.TSIGKey{Name: "nx-test", Secret: testKeySecret}},
+	}
+	zone := Zone{Name: "example.com", SOAInfo: SOAInfo{BindDefaultRRTTL: 120}}
+	for i := 0; i < maxUpdateChanges+10; i++ {
+		zone.Records = append(zone.Records, resourceRecord{Name: fmt.Sprintf("host%d", i), Type: A, RData: "10.0.0.5"})
+	}
+
+	_, _, err := newDynamicUpdater(primary).pushZone(zone, nil)
+	expected := fmt.Sprintf("%d of %d change(s) were applied", maxUpdateChanges, maxUpdateChanges+10)
+	if err == nil || !strings.Contains(err.Error(), expected) {
+		t.Errorf("Expected error reporting <%s>; but was <%v>", expected, err)
+	}
+}
@@ -1,8 +1,12 @@
 package dns
 
 import (
+	"peg.nu/nx/config"
 	"peg.nu/nx/model"
+	"strings"
 	"testing"
+
+	"github.com/go-test/deep"
 )
 
 func TestDomainNormalizing(t *testing.T) {
@@ -41,6 +45,52 @@ func TestDomainNormalizing(t *testing.T) {
 	})
 }
 
+func TestMostSpecificZone(t *testing.T) {
+	zones := []string{"peg.nu", "bue39.peg.nu", "example.co.uk"}
+
+	t.Run("max-max", func(t *testing.T) {
+		testZoneMatching(makeAddress("vm-ns-1.bue39.peg.nu", "", "bue39.peg.nu"), makeAddress("vm-ns-1", "", "bue39.peg.nu"), zones, t)
+	})
+	t.Run("min-max", func(t *testing.T) {
+		testZoneMatching(makeAddress("vm-ns-1", "", "bue39.peg.nu"), makeAddress("vm-ns-1", "", "bue39.peg.nu"), zones, t)
+	})
+	t.Run("max-min", func(t *testing.T) {
+		testZoneMatching(makeAddress("vm-ns-1.bue39.peg.nu", "", "peg.nu"), makeAddress("vm-ns-1", "", "bue39.peg.nu"), zones, t)
+	})
+	t.Run("deep-max", func(t *testing.T) {
+		testZoneMatching(makeAddress("vm.lab.bue39.peg.nu", "", "bue39.peg.nu"), makeAddress("vm.lab", "", "bue39.peg.nu"), zones, t)
+	})
+	t.Run("public-suffix", func(t *testing.T) {
+		testZoneMatching(makeAddress("www.shop.example.co.uk", "", "shop.example.co.uk"), makeAddress("www.shop", "", "example.co.uk"), zones, t)
+	})
+	t.Run("unconfigured", func(t *testing.T) {
+		testZoneMatching(makeAddress("plex.plox.rack.farm", "", "plox.rack.farm"), makeAddress("plex.plox", "", "rack.farm"), zones, t)
+	})
+	t.Run("apex", func(t *testing.T) {
+		testZoneMatching(makeAddress("bue39.peg.nu", "", "peg.nu"), makeAddress("@", "", "bue39.peg.nu"), zones, t)
+	})
+}
+
+func TestFallbackNames(t *testing.T) {
+	expectations := []struct {
+		address, pattern, expected string
+	}{
+		{"10.0.0.5/24", "", "ip-10-0-0-5"},
+		{"2001:db8::1/64", "", "ip-2001-db8--1"},
+		{"10.0.0.5/24", "host-{ip}-static", "host-10-0-0-5-static"},
+	}
+
+	for _, e := range expectations {
+		address := makeAddress("", "", "peg.nu")
+		address.IP.Address = e.address
+		FixFlattenAddress(&address, NamingOptions{FallbackNamePattern: e.pattern})
+
+		if address.IP.DnsName != e.expected {
+			t.Errorf("Expected fallback name of <%s> to be <%s>; but was <%s>", e.address, e.expected, address.IP.DnsName)
+		}
+	}
+}
+
 func TestDnsFieldFallback(t *testing.T) {
 	t.Run("DnsName only", func(t *testing.T) {
 		testDnsFieldPreference(makeAddress("fabianflu.ch", "", ""), "fabianflu.ch", t)
@@ -65,8 +115,12 @@ func makeAddress(dnsName, description, zone string) DNSIP {
 }
 
 func testNameFixing(original, expect DNSIP, t *testing.T) {
+	testZoneMatching(original, expect, nil, t)
+}
+
+func testZoneMatching(original, expect DNSIP, zones []string, t *testing.T) {
 	updated := original
-	FixFlattenAddress(&updated)
+	FixFlattenAddress(&updated, NamingOptions{Zones: zones})
 
 	if updated.IP.DnsName != expect.IP.GetName() {
 		t.Errorf("Expected Name to be <%s>; but was <%s>", expect.IP.GetName(), updated.IP.GetName())
@@ -83,3 +137,99 @@ func testDnsFieldPreference(a DNSIP, expect string, t *testing.T) {
 
 	}
 }
+
+func TestHostnameSanitizing(t *testing.T) {
+	expectations := map[string]string{
+		"vm-ns-1":               "vm-ns-1",
+		"web_01":                "web-01",
+		"müller-pc":             "xn--mller-pc-65a",
+		"Müller_PC":             "xn--mller-pc-65a",
+		"-über-":                "xn--ber-goa",
+		"web01.":                "web01",
+		"-web-.lab":             "web.lab",
+		"host..lab":             "host.lab",
+		"a&b@c":                 "a-b-c",
+		strings.Repeat("a", 70): strings.Repeat("a", 63),
+	}
+	for name, expected := range expectations {
+		sanitized, _, ok := sanitizeHostname(name, "peg.nu")
+		if !ok || sanitized != expected {
+			t.Errorf("Expected <%s> to be sanitized to <%s>; but was <%s> (ok: %v)", name, expected, sanitized, ok)
+		}
+	}
+
+	for _, name := range []string{"___", ".", strings.Repeat("a.", 130), strings.Repeat("ü", 60), "xn--" + strings.Repeat("a", 60)} {
+		if _, _, ok := sanitizeHostname(name, "peg.nu"); ok {
+			t.Errorf("Expected <%s> to be rejected", name)
+		}
+	}
+}
+
+func TestHostnamePolicies(t *testing.T) {
+	var sanitizations []nameSanitization
+
+	address := makeAddress("web_01", "", "peg.nu")
+	address.IP.ID = 42
+	address.CNames = []string{"www", "ftp_"}
+	if !sanitizeAddress(&address, policyReplace, &sanitizations) || address.IP.DnsName != "web-01" || len(address.CNames) != 2 || address.CNames[1] != "ftp" {
+		t.Errorf("Expected name and CNAMEs to be replaced; but got %s %v", address.IP.DnsName, address.CNames)
+	}
+	if len(sanitizations) != 2 || sanitizations[0].AddressID != 42 || sanitizations[0].Action != actionReplaced {
+		t.Errorf("Expected two replacements to be reported; but got %v", sanitizations)
+	}
+
+	address = makeAddress("web_01", "", "peg.nu")
+	if sanitizeAddress(&address, policyDrop, &sanitizations) {
+		t.Errorf("Expected address to be dropped")
+	}
+
+	address = makeAddress("web-01", "", "peg.nu")
+	address.CNames = []string{"www", "ftp_"}
+	if !sanitizeAddress(&address, policyFail, &sanitizations) || len(address.CNames) != 1 {
+		t.Errorf("Expected only the invalid CNAME to be skipped; but got %v", address.CNames)
+	}
+	if last := sanitizations[len(sanitizations)-1]; last.Action != actionFailed || last.Original != "ftp_" {
+		t.Errorf("Expected invalid CNAME to be reported as failed; but got %v", last)
+	}
+}
+
+func TestNormalizedAddresses(t *testing.T) {
+	conf := &config.NXConfig{Namespaces: config.NamespaceConfig{DNS: config.DNSNamespaceConfig{Primaries: []config.PrimaryConfig{
+		{Name: "ns1", Zones: []string{"example.com", "lab.example.com"}},
+	}}}}
+	prefix := model.IPAMPrefix{Prefix: "10.0.0.0/24", Tags: []model.Tag{
+		{Name: "nx:dns:enable[true]"}, {Name: "nx:dns:forward_zone[example.com]"},
+	}}
+	addresses := []model.IPAddress{
+		{ID: 1, Address: "10.0.0.5/24", DnsName: "Web Server", Prefix: &prefix, Tags: []model.Tag{{Name: "nx:dns:cname[www]"}, {Name: "nx:dns:cname[mail]"}}},
+		{ID: 2, Address: "10.0.0.6/24", DnsName: "db.lab.example.com", Prefix: &prefix},
+		{ID: 3, Address: "10.0.0.7/24", DnsName: "dropped", Prefix: &prefix},
+		{ID: 4, Address: "10.0.0.8/24", DnsName: "off", Prefix: &prefix, Tags: []model.Tag{{Name: "nx:dns:enable[false]"}}},
+	}
+	// the records of dropped and of the CNAME mail were removed by the conflict policy
+	zones := []Zone{
+		{Name: "example.com", View: "internal", Records: []resourceRecord{{Name: "web", Type: A, AddressID: 1}, {Name: "www", Type: CName, AddressID: 1}}},
+		{Name: "lab.example.com", Records: []resourceRecord{{Name: "db", Type: A, AddressID: 2}}},
+	}
+
+	normalized := NormalizedAddresses(addresses, zones, conf)
+
+	expected := []struct {
+		fqdn   string
+		cnames []string
+	}{
+		{"web.example.com", []string{"www"}},
+		{"db.lab.example.com", nil},
+	}
+	if len(normalized) != len(expected) {
+		t.Fatalf("Expected %d addresses; but was <%v>", len(expected), normalized)
+	}
+	for i, e := range expected {
+		if normalized[i].FQDN() != e.fqdn {
+			t.Errorf("Expected name <%s>; but was <%s>", e.fqdn, normalized[i].FQDN())
+		}
+		if diff := deep.Equal(normalized[i].CNames, e.cnames); diff != nil {
+			t.Error(diff)
+		}
+	}
+}
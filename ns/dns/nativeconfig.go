@@ -0,0 +1,274 @@
+package dns
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"peg.nu/nx/cache"
+	"peg.nu/nx/config"
+	"peg.nu/nx/util"
+)
+
+type serverSoftware string
+
+const (
+	softwareBind serverSoftware = "bind"
+	softwareKnot serverSoftware = "knot"
+	softwareNSD  serverSoftware = "nsd"
+)
+
+func primarySoftware(primary *config.PrimaryConfig) serverSoftware {
+	switch serverSoftware(primary.Software) {
+	case softwareBind, softwareKnot, softwareNSD:
+		return serverSoftware(primary.Software)
+	case "":
+		return softwareBind
+	}
+
+	logger.Printf("Unknown software <%s> of nameserver %s, using %s", primary.Software, primary.Name, softwareBind)
+	return softwareBind
+}
+
+// nativeRemote is a nameserver zones are transferred from or to
+type nativeRemote struct {
+	ID   string
+	IP   string
+	Port int
+	// Key signs all messages sent to the remote, empty if they are not signed
+	Key string
+}
+
+type nativeZone struct {
+	Name            string
+	FileName        string
+	IsSecondary     bool
+	IsDnssecEnabled bool
+	// DnssecPolicy is the configured policy the zone is signed with, empty for the default policy of the nameserver
+	DnssecPolicy string
+	// Primary is the remote the zone is transferred from if the zone is a secondary
+	Primary nativeRemote
+	// Secondaries are the additional secondaries of the zone, which are notified and allowed to transfer the zone
+	Secondaries []nativeRemote
+}
+
+type nativeConfigTemplateVars struct {
+	ServerName  string
+	ServerIP    string
+	GeneratedAt string
+	// TransferKey signs the transfers of the zones of this nameserver and the notifies sent to it
+	TransferKey string
+	KeysFile    string
+	// Primaries are all other primaries, which are secondaries of all zones of this nameserver
+	Primaries []nativeRemote
+	// AdditionalSecondaries are the additional secondaries of all zones
+	AdditionalSecondaries []nativeRemote
+	Zones                 []nativeZone
+	// DnssecPolicies are the configured policies used by the zones, only rendered for Knot DNS
+	DnssecPolicies []knotDnssecPolicy
+}
+
+// knotDnssecPolicy is a DNSSEC policy in the format of Knot DNS
+type knotDnssecPolicy struct {
+	Name string
+	// SingleTypeSigning signs the zone with a single combined signing key, the KSK lifetime is used for it
+	SingleTypeSigning bool
+	Algorithm         string
+	KSKLifetime       string
+	ZSKLifetime       string
+	NSEC3             bool
+	NSEC3Iterations   int
+	NSEC3OptOut       bool
+	NSEC3SaltLength   int
+}
+
+// isoDurationRegex matches the ISO 8601 durations BIND accepts as key lifetimes
+var isoDurationRegex = regexp.MustCompile("^P(?:(\\d+)Y)?(?:(\\d+)M)?(?:(\\d+)W)?(?:(\\d+)D)?(?:T(?:(\\d+)H)?(?:(\\d+)M)?(?:(\\d+)S)?)?$")
+
+// isoDurationUnits are the seconds of the units of isoDurationRegex, years and months have a fixed length like in BIND
+var isoDurationUnits = []int{365 * 86400, 30 * 86400, 7 * 86400, 86400, 3600, 60, 1}
+
+// ttlDurationRegex matches lifetimes in the TTL format, which BIND and Knot DNS both accept
+var ttlDurationRegex = regexp.MustCompile("^\\d+[smhdw]?$")
+
+// knotLifetime converts a key lifetime of the BIND format into the Knot DNS format, 0 being unlimited
+func knotLifetime(lifetime string) (string, error) {
+	lifetime = strings.ToLower(strings.TrimSpace(lifetime))
+	if lifetime == unlimitedKeyLifetime {
+		return "0", nil
+	}
+	if ttlDurationRegex.MatchString(lifetime) {
+		return lifetime, nil
+	}
+
+	matches := isoDurationRegex.FindStringSubmatch(strings.ToUpper(lifetime))
+	if matches == nil || lifetime == "p" || strings.HasSuffix(lifetime, "t") {
+		return "", fmt.Errorf("key lifetime <%s> is neither an ISO 8601 duration nor a TTL", lifetime)
+	}
+
+	seconds := 0
+	for i, match := range matches[1:] {
+		if len(match) == 0 {
+			continue
+		}
+		value, err := strconv.Atoi(match)
+		if err != nil {
+			return "", err
+		}
+		seconds += value * isoDurationUnits[i]
+	}
+
+	if seconds%86400 == 0 {
+		return fmt.Sprintf("%dd", seconds/86400), nil
+	}
+	return strconv.Itoa(seconds), nil
+}
+
+func newKnotDnssecPolicy(policy templateDnssecPolicy) knotDnssecPolicy {
+	knotPolicy := knotDnssecPolicy{
+		Name:            policy.Name,
+		NSEC3:           policy.NSEC3,
+		NSEC3Iterations: policy.NSEC3Iterations,
+		NSEC3OptOut:     policy.NSEC3OptOut,
+		NSEC3SaltLength: policy.NSEC3SaltLength,
+	}
+
+	for _, key := range policy.Keys {
+		knotPolicy.Algorithm = key.Algorithm
+		lifetime, err := knotLifetime(key.Lifetime)
+		if err != nil {
+			panic(fmt.Errorf("could not use DNSSEC policy %s for Knot DNS: %w", policy.Name, err))
+		}
+
+		switch key.Role {
+		case "csk":
+			knotPolicy.SingleTypeSigning = true
+			knotPolicy.KSKLifetime = lifetime
+		case "ksk":
+			knotPolicy.KSKLifetime = lifetime
+		case "zsk":
+			knotPolicy.ZSKLifetime = lifetime
+		}
+	}
+
+	return knotPolicy
+}
+
+func primaryRemote(primary config.PrimaryConfig) nativeRemote {
+	return nativeRemote{ID: fmt.Sprintf("nx-%s", primary.Name), IP: primary.IP, Port: primary.Port, Key: primary.TransferKey}
+}
+
+func additionalSecondaryRemotes(primary *config.PrimaryConfig, zone string) []nativeRemote {
+	var remotes []nativeRemote
+	for i, secondary := range primary.AdditionalSecondaries[zone] {
+		remotes = append(remotes, nativeRemote{ID: fmt.Sprintf("nx-secondary-%s-%d", canonicalizeZoneName(zone), i), IP: secondary.IP, Port: secondary.Port, Key: secondary.Key})
+	}
+
+	return remotes
+}
+
+// nativeConfigVars builds the template variables for nameservers without views. If the primary has views, the zone
+// files of its first view are used.
+func nativeConfigVars(currentPrimary config.PrimaryConfig, zones []string, conf *config.NXConfig) nativeConfigTemplateVars {
+	view := ""
+	if views := primaryViewNames(&currentPrimary); len(views) > 0 {
+		view = views[0]
+		logger.Printf("Views are not supported by %s, using the zones of view %s for nameserver %s", currentPrimary.Software, view, currentPrimary.Name)
+	}
+
+	vars := nativeConfigTemplateVars{
+		ServerName:  currentPrimary.Name,
+		ServerIP:    currentPrimary.IP,
+		GeneratedAt: time.Now().Format(time.RFC3339),
+		TransferKey: currentPrimary.TransferKey,
+	}
+
+	for _, zonesPrimary := range conf.Namespaces.DNS.Primaries {
+		isPrimary := zonesPrimary.Name == currentPrimary.Name
+		if !isPrimary {
+			vars.Primaries = append(vars.Primaries, primaryRemote(zonesPrimary))
+		}
+
+		for _, zone := range zonesPrimary.Zones {
+			if !util.SliceContainsString(zones, zone) {
+				continue
+			}
+
+			dnssecPolicy, dnssecEnabled := zoneDnssecPolicy(&zonesPrimary, zone)
+			if util.SliceContainsString(builtinDnssecPolicies, dnssecPolicy) {
+				dnssecPolicy = ""
+			}
+			nZone := nativeZone{
+				Name:            zone,
+				FileName:        zoneFileName(zone, view),
+				IsSecondary:     !isPrimary,
+				IsDnssecEnabled: dnssecEnabled,
+				DnssecPolicy:    dnssecPolicy,
+				Primary:         primaryRemote(zonesPrimary),
+			}
+			if isPrimary {
+				nZone.Secondaries = additionalSecondaryRemotes(&currentPrimary, zone)
+				vars.AdditionalSecondaries = append(vars.AdditionalSecondaries, nZone.Secondaries...)
+			}
+			vars.Zones = append(vars.Zones, nZone)
+		}
+	}
+
+	if primarySoftware(&currentPrimary) == softwareKnot {
+		for _, policy := range primaryDnssecPolicies(&currentPrimary, zones, conf) {
+			vars.DnssecPolicies = append(vars.DnssecPolicies, newKnotDnssecPolicy(policy))
+		}
+	}
+
+	return vars
+}
+
+// generateNativeConfigs renders the configs of all nameservers running Knot DNS or NSD
+func generateNativeConfigs(zones []string, transferKeys map[string]config.TSIGKey, conf *config.NXConfig) {
+	writers := map[serverSoftware]*cache.CachedTemplateWriter{}
+	keysWriters := map[serverSoftware]*cache.CachedTemplateWriter{}
+	for _, software := range []serverSoftware{softwareKnot, softwareNSD} {
+		templateString, err := os.ReadFile(fmt.Sprintf("templates/%s-config.tmpl", software))
+		if err != nil {
+			panic(err)
+		}
+		configTemplate := template.Must(template.New("config").Parse(string(templateString)))
+		ignoreRegexes := []*regexp.Regexp{
+			regexp.MustCompile("(?m)^# Generated at.*$"),
+		}
+		writers[software] = cache.New(configTemplate, ignoreRegexes, false)
+		keysWriters[software] = newKeysWriter(software)
+	}
+
+	for _, currentPrimary := range conf.Namespaces.DNS.Primaries {
+		software := primarySoftware(&currentPrimary)
+		if primaryOutput(&currentPrimary) != outputFiles || software == softwareBind {
+			continue
+		}
+
+		vars := nativeConfigVars(currentPrimary, zones, conf)
+		if keys, _ := primaryKeys(currentPrimary, transferKeys, conf); len(keys) > 0 {
+			writeKeysFile(keysWriters[software], fmt.Sprintf("generated/%s-config", software), currentPrimary.Name, keys)
+			vars.KeysFile = keysFileName(currentPrimary.Name)
+		}
+
+		_, err := writers[software].WriteTemplate(
+			fmt.Sprintf("generated/%s-config/%s.conf", software, currentPrimary.Name),
+			vars,
+		)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	for _, software := range []serverSoftware{softwareKnot, softwareNSD} {
+		processedFiles := append(writers[software].ProcessedFiles, keysWriters[software].ProcessedFiles...)
+		util.CleanDirectoryExcept(fmt.Sprintf("generated/%s-config", software), processedFiles, conf)
+		conf.UpdatedFiles = append(conf.UpdatedFiles, writers[software].UpdatedFiles...)
+		conf.UpdatedFiles = append(conf.UpdatedFiles, keysWriters[software].UpdatedFiles...)
+	}
+}
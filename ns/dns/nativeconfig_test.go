@@ -0,0 +1,155 @@
+package dns
+
+import (
+	"testing"
+
+	"github.com/go-test/deep"
+	"peg.nu/nx/config"
+)
+
+func TestNativeConfigVars(t *testing.T) {
+	conf := &config.NXConfig{Namespaces: config.NamespaceConfig{DNS: config.DNSNamespaceConfig{Primaries: []config.PrimaryConfig{
+		{
+			Name:                  "ns1.example.com",
+			IP:                    "192.0.2.1",
+			Zones:                 []string{"example.com", "empty.example.com"},
+			DnssecZones:           []string{"example.com"},
+			Software:              "knot",
+			AdditionalSecondaries: config.AdditionalSecondariesConfig{"example.com": {{IP: "198.51.100.1", Port: 5300}}},
+			Views:                 []config.DNSView{{Name: "internal"}},
+		},
+		{
+			Name:     "ns2.example.com",
+			IP:       "192.0.2.2",
+			Port:     5353,
+			Zones:    []string{"example.net"},
+			Software: "nsd",
+		},
+	}}}}
+
+	vars := nativeConfigVars(conf.Namespaces.DNS.Primaries[0], []string{"example.com", "example.net"}, conf)
+	vars.GeneratedAt = ""
+
+	secondary := nativeRemote{ID: "nx-secondary-5ababd60-0", IP: "198.51.100.1", Port: 5300}
+	expected := nativeConfigTemplateVars{
+		ServerName:            "ns1.example.com",
+		ServerIP:              "192.0.2.1",
+		Primaries:             []nativeRemote{{ID: "nx-ns2.example.com", IP: "192.0.2.2", Port: 5353}},
+		AdditionalSecondaries: []nativeRemote{secondary},
+		Zones: []nativeZone{
+			{
+				Name:            "example.com",
+				FileName:        "example.com@internal.db",
+				IsDnssecEnabled: true,
+				Primary:         nativeRemote{ID: "nx-ns1.example.com", IP: "192.0.2.1"},
+				Secondaries:     []nativeRemote{secondary},
+			},
+			{
+				Name:        "example.net",
+				FileName:    "example.net@internal.db",
+				IsSecondary: true,
+				Primary:     nativeRemote{ID: "nx-ns2.example.com", IP: "192.0.2.2", Port: 5353},
+			},
+		},
+	}
+
+	if diff := deep.Equal(vars, expected); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestPrimarySoftware(t *testing.T) {
+	tests := map[string]serverSoftware{"": softwareBind, "bind": softwareBind, "knot": softwareKnot, "nsd": softwareNSD, "unbound": softwareBind}
+	for software, expected := range tests {
+		if actual := primarySoftware(&config.PrimaryConfig{Software: software}); actual != expected {
+			t.Errorf("Expected software <%s> for <%s>; but was <%s>", expected, software, actual)
+		}
+	}
+}
+
+func TestNativeConfigVarsTransferKeys(t *testing.T) {
+	conf := &config.NXConfig{Namespaces: config.NamespaceConfig{DNS: config.DNSNamespaceConfig{Primaries: []config.PrimaryConfig{
+		{
+			Name:                  "ns1.example.com",
+			IP:                    "192.0.2.1",
+			Zones:                 []string{"example.com"},
+			Software:              "nsd",
+			TransferKey:           "ns1-transfer",
+			AdditionalSecondaries: config.AdditionalSecondariesConfig{"example.com": {{IP: "198.51.100.1", Key: "secondary-transfer"}}},
+		},
+		{
+			Name:        "ns2.example.com",
+			IP:          "192.0.2.2",
+			Zones:       []string{"example.net"},
+			TransferKey: "ns2-transfer",
+		},
+	}}}}
+
+	vars := nativeConfigVars(conf.Namespaces.DNS.Primaries[0], []string{"example.com", "example.net"}, conf)
+
+	if vars.TransferKey != "ns1-transfer" {
+		t.Errorf("Expected transfer key <ns1-transfer>; but was <%s>", vars.TransferKey)
+	}
+	expectedPrimaries := []nativeRemote{{ID: "nx-ns2.example.com", IP: "192.0.2.2", Key: "ns2-transfer"}}
+	if diff := deep.Equal(vars.Primaries, expectedPrimaries); diff != nil {
+		t.Error(diff)
+	}
+	expectedSecondaries := []nativeRemote{{ID: "nx-secondary-5ababd60-0", IP: "198.51.100.1", Key: "secondary-transfer"}}
+	if diff := deep.Equal(vars.AdditionalSecondaries, expectedSecondaries); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestKnotLifetime(t *testing.T) {
+	tests := map[string]string{
+		unlimitedKeyLifetime: "0",
+		"P1Y":                "365d",
+		"P90D":               "90d",
+		"P1M2W":              "44d",
+		"PT1H30M":            "5400",
+		"90d":                "90d",
+		"3600":               "3600",
+		"P":                  "",
+		"PT":                 "",
+		"one year":           "",
+	}
+	for lifetime, expected := range tests {
+		actual, err := knotLifetime(lifetime)
+		if len(expected) == 0 {
+			if err == nil {
+				t.Errorf("Expected an error for lifetime <%s>; but was <%s>", lifetime, actual)
+			}
+			continue
+		}
+		if err != nil || actual != expected {
+			t.Errorf("Expected <%s> for lifetime <%s>; but was <%s> (%v)", expected, lifetime, actual, err)
+		}
+	}
+}
+
+func TestNativeConfigVarsKnotDnssecPolicies(t *testing.T) {
+	primary := dnssecTestConf.Namespaces.DNS.Primaries[0]
+	primary.Software = "knot"
+	conf := &config.NXConfig{Namespaces: config.NamespaceConfig{DNS: config.DNSNamespaceConfig{
+		DnssecPolicies: dnssecTestConf.Namespaces.DNS.DnssecPolicies,
+		Primaries:      []config.PrimaryConfig{primary},
+	}}}
+
+	vars := nativeConfigVars(primary, primary.Zones, conf)
+
+	expectedPolicies := []knotDnssecPolicy{
+		{Name: "simple", SingleTypeSigning: true, Algorithm: defaultDnssecAlgorithm, KSKLifetime: "0"},
+		{Name: "strict", Algorithm: "ecdsap384sha384", KSKLifetime: "365d", ZSKLifetime: "90d", NSEC3: true},
+	}
+	if diff := deep.Equal(vars.DnssecPolicies, expectedPolicies); diff != nil {
+		t.Error(diff)
+	}
+
+	// zones with the default policy use the default policy of Knot DNS
+	expectedZonePolicies := map[string]string{"example.com": "strict", "lab.example.com": "simple", "168.192.in-addr.arpa": ""}
+	for _, zone := range vars.Zones {
+		if zone.DnssecPolicy != expectedZonePolicies[zone.Name] || !zone.IsDnssecEnabled {
+			t.Errorf("Expected signed zone %s with policy <%s>; but was <%s> (signed: %v)", zone.Name, expectedZonePolicies[zone.Name], zone.DnssecPolicy, zone.IsDnssecEnabled)
+		}
+	}
+}
@@ -0,0 +1,519 @@
+package dns
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	mdns "github.com/miekg/dns"
+	"peg.nu/nx/cache"
+	"peg.nu/nx/config"
+	"peg.nu/nx/util"
+)
+
+const (
+	// outputPowerDNSAPI patches the changed rrsets using the PowerDNS HTTP API
+	outputPowerDNSAPI outputMode = "powerdns_api"
+	// outputPowerDNSSQL writes SQL statements for the PowerDNS generic SQL backends
+	outputPowerDNSSQL outputMode = "powerdns_sql"
+)
+
+const defaultPowerDNSServerID = "localhost"
+const defaultPowerDNSZoneKind = "Native"
+
+type pdnsRecord struct {
+	Content  string `json:"content"`
+	Disabled bool   `json:"disabled"`
+}
+
+type pdnsRRSet struct {
+	Name       string       `json:"name"`
+	Type       string       `json:"type"`
+	TTL        uint32       `json:"ttl,omitempty"`
+	ChangeType string       `json:"changetype,omitempty"`
+	Records    []pdnsRecord `json:"records,omitempty"`
+}
+
+type pdnsZone struct {
+	ID          string      `json:"id,omitempty"`
+	Name        string      `json:"name"`
+	Kind        string      `json:"kind,omitempty"`
+	Nameservers []string    `json:"nameservers,omitempty"`
+	RRSets      []pdnsRRSet `json:"rrsets,omitempty"`
+}
+
+type powerDNSClient struct {
+	baseURL    string
+	apiKey     string
+	zoneKind   string
+	httpClient *http.Client
+}
+
+func newPowerDNSClient(pdnsConfig config.PowerDNSConfig) powerDNSClient {
+	serverID := pdnsConfig.ServerID
+	if len(serverID) == 0 {
+		serverID = defaultPowerDNSServerID
+	}
+	zoneKind := pdnsConfig.ZoneKind
+	if len(zoneKind) == 0 {
+		zoneKind = defaultPowerDNSZoneKind
+	}
+
+	return powerDNSClient{
+		baseURL:    fmt.Sprintf("%s/api/v1/servers/%s", strings.TrimSuffix(pdnsConfig.URL, "/"), url.PathEscape(serverID)),
+		apiKey:     pdnsConfig.ApiKey,
+		zoneKind:   zoneKind,
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// request sends the body as JSON and decodes the response into result if it is not nil. The returned status code is
+// set for all responses, err only for transport errors and unexpected status codes.
+func (c powerDNSClient) request(method, path string, body interface{}, result interface{}, expectedStatus ...int) (int, error) {
+	var reader io.Reader
+	if body != nil {
+		payload, err := json.Marshal(body)
+		if err != nil {
+			return 0, err
+		}
+		reader = bytes.NewReader(payload)
+	}
+
+	req, err := http.NewRequest(method, c.baseURL+path, reader)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("X-API-Key", c.apiKey)
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	responseBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return resp.StatusCode, err
+	}
+
+	for _, status := range expectedStatus {
+		if resp.StatusCode != status {
+			continue
+		}
+		if result != nil && len(responseBody) > 0 {
+			return resp.StatusCode, json.Unmarshal(responseBody, result)
+		}
+		return resp.StatusCode, nil
+	}
+
+	return resp.StatusCode, fmt.Errorf("%s %s returned %s: %s", method, path, resp.Status, strings.TrimSpace(string(responseBody)))
+}
+
+// getZone returns the zone including its rrsets or nil if it does not exist. Other errors, like the 422 PowerDNS returns
+// for invalid requests, are returned with their body.
+func (c powerDNSClient) getZone(name string) (*pdnsZone, error) {
+	zone := &pdnsZone{}
+	status, err := c.request(http.MethodGet, "/zones/"+url.PathEscape(mdns.Fqdn(name)), nil, zone, http.StatusOK, http.StatusNotFound)
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusOK {
+		return nil, nil
+	}
+
+	return zone, nil
+}
+
+func (c powerDNSClient) createZone(name string, nameservers []string) (*pdnsZone, error) {
+	zone := &pdnsZone{}
+	_, err := c.request(http.MethodPost, "/zones", pdnsZone{Name: mdns.Fqdn(name), Kind: c.zoneKind, Nameservers: nameservers}, zone, http.StatusCreated)
+	return zone, err
+}
+
+func (c powerDNSClient) patchZone(zone *pdnsZone, rrsets []pdnsRRSet) error {
+	_, err := c.request(http.MethodPatch, "/zones/"+url.PathEscape(zone.ID), pdnsZone{RRSets: rrsets}, nil, http.StatusNoContent, http.StatusOK)
+	return err
+}
+
+// rrContent returns the presentation format of the RData of the record
+func rrContent(rr mdns.RR) string {
+	return strings.TrimPrefix(rr.String(), rr.Header().String())
+}
+
+// pdnsRRSets groups the records into rrsets sorted by name and type
+func pdnsRRSets(rrs []mdns.RR) []pdnsRRSet {
+	var keys []string
+	rrsets := make(map[string]*pdnsRRSet)
+	for _, rr := range rrs {
+		header := rr.Header()
+		name := strings.ToLower(header.Name)
+		rrType := mdns.TypeToString[header.Rrtype]
+		key := name + " " + rrType
+
+		rrset, ok := rrsets[key]
+		if !ok {
+			rrset = &pdnsRRSet{Name: name, Type: rrType, TTL: header.Ttl}
+			rrsets[key] = rrset
+			keys = append(keys, key)
+		}
+
+		record := pdnsRecord{Content: rrContent(rr)}
+		if !containsPdnsRecord(rrset.Records, record) {
+			rrset.Records = append(rrset.Records, record)
+		}
+	}
+	sort.Strings(keys)
+
+	result := make([]pdnsRRSet, 0, len(keys))
+	for _, key := range keys {
+		result = append(result, *rrsets[key])
+	}
+
+	return result
+}
+
+func containsPdnsRecord(records []pdnsRecord, record pdnsRecord) bool {
+	for _, existing := range records {
+		if strings.EqualFold(existing.Content, record.Content) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func sameRRSet(a, b pdnsRRSet) bool {
+	if a.TTL != b.TTL || len(a.Records) != len(b.Records) {
+		return false
+	}
+	for _, record := range a.Records {
+		if !containsPdnsRecord(b.Records, record) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// isManagedRRSet returns true if the rrset has a type managed by nx and is not the apex NS rrset
+func isManagedRRSet(rrset pdnsRRSet, zone string) bool {
+	rrType, ok := mdns.StringToType[rrset.Type]
+	if !ok {
+		return false
+	}
+
+	return isManaged(&mdns.RR_Header{Name: rrset.Name, Rrtype: rrType}, zone)
+}
+
+// diffRRSets returns the rrset changes to reach desired from the current rrsets of the zone
+func diffRRSets(zone string, current, desired []pdnsRRSet) []pdnsRRSet {
+	currentRRSets := make(map[string]pdnsRRSet)
+	for _, rrset := range current {
+		if isManagedRRSet(rrset, zone) {
+			currentRRSets[strings.ToLower(rrset.Name)+" "+rrset.Type] = rrset
+		}
+	}
+
+	var changes []pdnsRRSet
+	desiredKeys := make(map[string]bool)
+	for _, rrset := range desired {
+		key := rrset.Name + " " + rrset.Type
+		desiredKeys[key] = true
+
+		if existing, ok := currentRRSets[key]; ok && sameRRSet(existing, rrset) {
+			continue
+		}
+		rrset.ChangeType = "REPLACE"
+		changes = append(changes, rrset)
+	}
+
+	var deletions []string
+	for key := range currentRRSets {
+		if !desiredKeys[key] {
+			deletions = append(deletions, key)
+		}
+	}
+	sort.Strings(deletions)
+	for _, key := range deletions {
+		changes = append(changes, pdnsRRSet{Name: currentRRSets[key].Name, Type: currentRRSets[key].Type, ChangeType: "DELETE"})
+	}
+
+	return changes
+}
+
+// pushZone patches the zone in PowerDNS to match the generated zone, creating it if it does not exist yet
+func (c powerDNSClient) pushZone(zone Zone) (int, error) {
+	rrs, err := zoneRRs(zone)
+	if err != nil {
+		return 0, err
+	}
+
+	current, err := c.getZone(zone.Name)
+	if err != nil {
+		return 0, err
+	}
+	if current == nil {
+		logger.Printf("Creating zone %s in PowerDNS", zone.Name)
+		current, err = c.createZone(zone.Name, []string{zone.SOAInfo.NameserverFQDN})
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	changes := diffRRSets(zone.Name, current.RRSets, pdnsRRSets(rrs))
+	if len(changes) == 0 {
+		return 0, nil
+	}
+
+	return len(changes), c.patchZone(current, changes)
+}
+
+// PushPowerDNS patches the changed rrsets of all zones of primaries with the PowerDNS API output
+func PushPowerDNS(zones []Zone, conf *config.NXConfig) error {
+	var errs []error
+	for _, zone := range zones {
+		primary := util.FindPrimaryForZone(*conf, zone.Name)
+		if primaryOutput(primary) != outputPowerDNSAPI {
+			continue
+		}
+		if len(zone.View) > 0 {
+			logger.Printf("Views are not supported by the PowerDNS output, skipping zone %s in view %s", zone.Name, zone.View)
+			continue
+		}
+
+		changes, err := newPowerDNSClient(primary.PowerDNS).pushZone(zone)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("could not update zone %s in PowerDNS: %w", zone.Name, err))
+			continue
+		}
+		logger.Printf("Updated zone %s in PowerDNS: %d rrset(s) changed\n", zone.Name, changes)
+	}
+
+	return errors.Join(errs...)
+}
+
+type sqlBackend string
+
+const (
+	sqlBackendMySQL  sqlBackend = "gmysql"
+	sqlBackendSQLite sqlBackend = "gsqlite3"
+)
+
+func parseSQLBackend(backend string) sqlBackend {
+	switch sqlBackend(backend) {
+	case sqlBackendMySQL, sqlBackendSQLite:
+		return sqlBackend(backend)
+	case "":
+		return sqlBackendMySQL
+	}
+
+	logger.Printf("Unknown PowerDNS SQL backend <%s>, using %s", backend, sqlBackendMySQL)
+	return sqlBackendMySQL
+}
+
+// quote returns the value as SQL string literal of the backend
+func (b sqlBackend) quote(value string) string {
+	if b == sqlBackendMySQL {
+		value = strings.ReplaceAll(value, "\\", "\\\\")
+	}
+
+	return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+}
+
+func (b sqlBackend) insertIgnore() string {
+	if b == sqlBackendSQLite {
+		return "INSERT OR IGNORE"
+	}
+
+	return "INSERT IGNORE"
+}
+
+// sqlName returns the name as stored by the generic SQL backends, in lowercase and without trailing dot
+func sqlName(name string) string {
+	return strings.TrimSuffix(strings.ToLower(name), ".")
+}
+
+// sqlRecord contains the SQL literals of a record
+type sqlRecord struct {
+	Name    string
+	Type    string
+	Content string
+	TTL     uint32
+	Auth    int
+}
+
+// sqlZone contains the SQL literals of a zone and its records
+type sqlZone struct {
+	Comment string
+	Name    string
+	Kind    string
+	Records []sqlRecord
+}
+
+type powerDNSSQLTemplateVars struct {
+	ServerName   string
+	Backend      sqlBackend
+	InsertIgnore string
+	ManagedTypes string
+	GeneratedAt  string
+	Zones        []sqlZone
+}
+
+// newSQLZone converts the zone including SOA and apex NS into SQL literals. Names in the content are stored without
+// trailing dot.
+func newSQLZone(zone Zone, kind string, backend sqlBackend) (sqlZone, error) {
+	soa, err := zoneSOA(zone)
+	if err != nil {
+		return sqlZone{}, err
+	}
+	rrs, err := zoneRRs(zone)
+	if err != nil {
+		return sqlZone{}, err
+	}
+
+	var cuts []string
+	for _, rr := range rrs {
+		if rr.Header().Rrtype == mdns.TypeNS && !strings.EqualFold(rr.Header().Name, soa.Hdr.Name) {
+			cuts = append(cuts, strings.ToLower(rr.Header().Name))
+		}
+	}
+
+	soaContent := fmt.Sprintf("%s %s %d %d %d %d %d", sqlName(soa.Ns), sqlName(soa.Mbox), soa.Serial, soa.Refresh, soa.Retry, soa.Expire, soa.Minttl)
+	records := []sqlRecord{
+		{Name: backend.quote(sqlName(zone.Name)), Type: backend.quote("SOA"), Content: backend.quote(soaContent), TTL: soa.Hdr.Ttl, Auth: 1},
+		{Name: backend.quote(sqlName(zone.Name)), Type: backend.quote("NS"), Content: backend.quote(sqlName(zone.SOAInfo.NameserverFQDN)), TTL: soa.Hdr.Ttl, Auth: 1},
+	}
+
+	for _, rr := range rrs {
+		var content string
+		switch typed := rr.(type) {
+		case *mdns.CNAME:
+			content = sqlName(typed.Target)
+		case *mdns.PTR:
+			content = sqlName(typed.Ptr)
+		case *mdns.NS:
+			content = sqlName(typed.Ns)
+		default:
+			content = rrContent(rr)
+		}
+
+		record := sqlRecord{
+			Name:    backend.quote(sqlName(rr.Header().Name)),
+			Type:    backend.quote(mdns.TypeToString[rr.Header().Rrtype]),
+			Content: backend.quote(content),
+			TTL:     rr.Header().Ttl,
+			Auth:    1,
+		}
+		if isBelowCut(rr, cuts) {
+			record.Auth = 0
+		}
+		if !containsSQLRecord(records, record) {
+			records = append(records, record)
+		}
+	}
+
+	return sqlZone{Comment: zone.Name, Name: backend.quote(sqlName(zone.Name)), Kind: backend.quote(strings.ToUpper(kind)), Records: records}, nil
+}
+
+// isBelowCut returns true if the record is at or below a delegation point and therefore not authoritative. DS records at
+// the delegation point belong to the zone.
+func isBelowCut(rr mdns.RR, cuts []string) bool {
+	name := strings.ToLower(rr.Header().Name)
+	for _, cut := range cuts {
+		if name == cut && rr.Header().Rrtype == mdns.TypeDS {
+			continue
+		}
+		if mdns.IsSubDomain(cut, name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func containsSQLRecord(records []sqlRecord, record sqlRecord) bool {
+	for _, existing := range records {
+		if existing == record {
+			return true
+		}
+	}
+
+	return false
+}
+
+// GeneratePowerDNSSQL writes the SQL statements replacing the records of all zones of primaries with the PowerDNS SQL
+// output
+func GeneratePowerDNSSQL(zones []Zone, conf *config.NXConfig) {
+	templateString, err := os.ReadFile("templates/powerdns-sql.tmpl")
+	if err != nil {
+		panic(err)
+	}
+	sqlTemplate := template.Must(template.New("powerdns-sql").Parse(string(templateString)))
+	ignoreRegexes := []*regexp.Regexp{
+		regexp.MustCompile("(?m)^-- Generated at .*$"),
+		regexp.MustCompile("(?m)^INSERT INTO records .*'SOA'.*$"),
+	}
+	cw := cache.New(sqlTemplate, ignoreRegexes, false)
+
+	managedTypeNames := []string{"'SOA'"}
+	for _, managedType := range managedTypes {
+		managedTypeNames = append(managedTypeNames, "'"+mdns.TypeToString[managedType]+"'")
+	}
+
+	for _, primary := range conf.Namespaces.DNS.Primaries {
+		if primaryOutput(&primary) != outputPowerDNSSQL {
+			continue
+		}
+
+		backend := parseSQLBackend(primary.PowerDNS.SQLBackend)
+		kind := primary.PowerDNS.ZoneKind
+		if len(kind) == 0 {
+			kind = defaultPowerDNSZoneKind
+		}
+
+		templateVars := powerDNSSQLTemplateVars{
+			ServerName:   primary.Name,
+			Backend:      backend,
+			InsertIgnore: backend.insertIgnore(),
+			ManagedTypes: strings.Join(managedTypeNames, ", "),
+			GeneratedAt:  time.Now().Format(time.RFC3339),
+		}
+		for _, zone := range zones {
+			if !util.SliceContainsString(primary.Zones, zone.Name) {
+				continue
+			}
+			if len(zone.View) > 0 {
+				logger.Printf("Views are not supported by the PowerDNS output, skipping zone %s in view %s", zone.Name, zone.View)
+				continue
+			}
+
+			sqlZone, err := newSQLZone(zone, kind, backend)
+			if err != nil {
+				panic(err)
+			}
+			templateVars.Zones = append(templateVars.Zones, sqlZone)
+		}
+
+		_, err := cw.WriteTemplate(fmt.Sprintf("generated/powerdns/%s.sql", primary.Name), templateVars)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	util.CleanDirectoryExcept("generated/powerdns", cw.ProcessedFiles, conf)
+	conf.UpdatedFiles = append(conf.UpdatedFiles, cw.UpdatedFiles...)
+}
@@ -0,0 +1,203 @@
+package dns
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/go-test/deep"
+	"peg.nu/nx/config"
+)
+
+// powerDNSStub is a minimal PowerDNS API keeping the zones in memory
+type powerDNSStub struct {
+	mu      sync.Mutex
+	zones   map[string]*pdnsZone
+	patches [][]pdnsRRSet
+}
+
+func (s *powerDNSStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if r.Header.Get("X-API-Key") != "secret" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	const prefix = "/api/v1/servers/localhost/zones"
+	zoneID := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, prefix), "/")
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == prefix:
+		var zone pdnsZone
+		_ = json.NewDecoder(r.Body).Decode(&zone)
+		zone.ID = zone.Name
+		for _, nameserver := range zone.Nameservers {
+			zone.RRSets = append(zone.RRSets, pdnsRRSet{Name: zone.Name, Type: "NS", TTL: 3600, Records: []pdnsRecord{{Content: nameserver}}})
+		}
+		zone.RRSets = append(zone.RRSets, pdnsRRSet{Name: zone.Name, Type: "SOA", TTL: 3600, Records: []pdnsRecord{{Content: "a.misconfigured.dns.server.invalid. hostmaster.example.com. 1 10800 3600 604800 3600"}}})
+		s.zones[zone.ID] = &zone
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(zone)
+	case r.Method == http.MethodGet && s.zones[zoneID] != nil:
+		_ = json.NewEncoder(w).Encode(s.zones[zoneID])
+	case r.Method == http.MethodPatch && s.zones[zoneID] != nil:
+		var patch pdnsZone
+		_ = json.NewDecoder(r.Body).Decode(&patch)
+		s.patches = append(s.patches, patch.RRSets)
+
+		zone := s.zones[zoneID]
+		for _, change := range patch.RRSets {
+			var kept []pdnsRRSet
+			for _, rrset := range zone.RRSets {
+				if rrset.Name != change.Name || rrset.Type != change.Type {
+					kept = append(kept, rrset)
+				}
+			}
+			if change.ChangeType == "REPLACE" {
+				change.ChangeType = ""
+				kept = append(kept, change)
+			}
+			zone.RRSets = kept
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte(`{"error": "Not Found"}`))
+	}
+}
+
+func TestPowerDNSPushZone(t *testing.T) {
+	stub := &powerDNSStub{zones: make(map[string]*pdnsZone)}
+	server := httptest.NewServer(stub)
+	defer server.Close()
+
+	client := newPowerDNSClient(config.PowerDNSConfig{URL: server.URL + "/", ApiKey: "secret"})
+	zone := Zone{Name: "example.com", SOAInfo: SOAInfo{NameserverFQDN: "ns1.example.com.", BindDefaultRRTTL: 120}, Records: []resourceRecord{
+		{Name: "web", Type: A, RData: "10.0.0.5"},
+		{Name: "web", Type: A, RData: "10.0.0.6"},
+		{Name: "www", Type: CName, RData: "web"},
+	}}
+
+	changes, err := client.pushZone(zone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changes != 2 {
+		t.Errorf("Expected 2 changed rrsets; but was %d", changes)
+	}
+
+	expected := []pdnsRRSet{
+		{Name: "web.example.com.", Type: "A", TTL: 120, ChangeType: "REPLACE", Records: []pdnsRecord{{Content: "10.0.0.5"}, {Content: "10.0.0.6"}}},
+		{Name: "www.example.com.", Type: "CNAME", TTL: 120, ChangeType: "REPLACE", Records: []pdnsRecord{{Content: "web.example.com."}}},
+	}
+	if diff := deep.Equal(stub.patches[0], expected); diff != nil {
+		t.Error(diff)
+	}
+
+	// unchanged zones are not patched
+	if changes, err = client.pushZone(zone); err != nil || changes != 0 || len(stub.patches) != 1 {
+		t.Errorf("Expected no changes for an unchanged zone; but got %d changes, err %v", changes, err)
+	}
+
+	zone.Records = []resourceRecord{{Name: "web", Type: A, RData: "10.0.0.5"}}
+	if _, err = client.pushZone(zone); err != nil {
+		t.Fatal(err)
+	}
+	expected = []pdnsRRSet{
+		{Name: "web.example.com.", Type: "A", TTL: 120, ChangeType: "REPLACE", Records: []pdnsRecord{{Content: "10.0.0.5"}}},
+		{Name: "www.example.com.", Type: "CNAME", ChangeType: "DELETE"},
+	}
+	if diff := deep.Equal(stub.patches[1], expected); diff != nil {
+		t.Error(diff)
+	}
+
+	// the SOA and apex NS rrsets are left to PowerDNS
+	for _, rrset := range stub.zones["example.com."].RRSets {
+		if rrset.Type == "NS" && rrset.Records[0].Content != "ns1.example.com." {
+			t.Errorf("Expected apex NS to be kept; but was <%v>", rrset)
+		}
+	}
+}
+
+func TestPowerDNSAuthenticationError(t *testing.T) {
+	server := httptest.NewServer(&powerDNSStub{zones: make(map[string]*pdnsZone)})
+	defer server.Close()
+
+	client := newPowerDNSClient(config.PowerDNSConfig{URL: server.URL, ApiKey: "wrong"})
+	if _, err := client.pushZone(Zone{Name: "example.com"}); err == nil {
+		t.Errorf("Expected an error for an invalid API key")
+	}
+}
+
+func TestPowerDNSUnprocessableZone(t *testing.T) {
+	stub := &powerDNSStub{zones: make(map[string]*pdnsZone)}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			_, _ = w.Write([]byte(`{"error": "Invalid zone name"}`))
+			return
+		}
+		stub.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	client := newPowerDNSClient(config.PowerDNSConfig{URL: server.URL, ApiKey: "secret"})
+	_, err := client.pushZone(Zone{Name: "example.com"})
+	if err == nil || !strings.Contains(err.Error(), "Invalid zone name") {
+		t.Errorf("Expected the error of the request; but was <%v>", err)
+	}
+	if len(stub.zones) != 0 {
+		t.Errorf("Expected no zone to be created; but was <%v>", stub.zones)
+	}
+}
+
+func TestNewSQLZone(t *testing.T) {
+	zone := Zone{
+		Name: "example.com",
+		SOAInfo: SOAInfo{
+			NameserverFQDN:        "ns1.example.com.",
+			DottedMailResponsible: "first\\.last.example.com",
+			TTL:                   600,
+			Refresh:               900,
+			Retry:                 900,
+			Expire:                172800,
+			BindDefaultRRTTL:      120,
+			Serial:                "24010100",
+		},
+		Records: []resourceRecord{
+			{Name: "web", Type: A, RData: "10.0.0.5"},
+			{Name: "www", Type: CName, RData: "web"},
+			{Name: "o'brien", Type: A, RData: "10.0.0.6"},
+			{Name: "lab", Type: NS, RData: "ns1.lab"},
+			{Name: "lab", Type: DS, RData: "12345 13 2 0123456789ABCDEF"},
+			{Name: "ns1.lab", Type: A, RData: "10.0.0.53"},
+		},
+	}
+
+	actual, err := newSQLZone(zone, "native", sqlBackendMySQL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := sqlZone{Comment: "example.com", Name: "'example.com'", Kind: "'NATIVE'", Records: []sqlRecord{
+		{Name: "'example.com'", Type: "'SOA'", Content: "'ns1.example.com first\\\\.last.example.com 24010100 900 900 172800 600'", TTL: 120, Auth: 1},
+		{Name: "'example.com'", Type: "'NS'", Content: "'ns1.example.com'", TTL: 120, Auth: 1},
+		{Name: "'web.example.com'", Type: "'A'", Content: "'10.0.0.5'", TTL: 120, Auth: 1},
+		{Name: "'www.example.com'", Type: "'CNAME'", Content: "'web.example.com'", TTL: 120, Auth: 1},
+		{Name: "'o''brien.example.com'", Type: "'A'", Content: "'10.0.0.6'", TTL: 120, Auth: 1},
+		{Name: "'lab.example.com'", Type: "'NS'", Content: "'ns1.lab.example.com'", TTL: 120, Auth: 0},
+		{Name: "'lab.example.com'", Type: "'DS'", Content: "'12345 13 2 0123456789ABCDEF'", TTL: 120, Auth: 1},
+		{Name: "'ns1.lab.example.com'", Type: "'A'", Content: "'10.0.0.53'", TTL: 120, Auth: 0},
+	}}
+	if diff := deep.Equal(actual, expected); diff != nil {
+		t.Error(diff)
+	}
+
+	if quoted := sqlBackendSQLite.quote("a\\b'c"); quoted != "'a\\b''c'" {
+		t.Errorf("Expected <'a\\b''c'>; but was <%s>", quoted)
+	}
+}
@@ -0,0 +1,69 @@
+package dns
+
+import (
+	"bytes"
+	"net"
+	"sort"
+	"strings"
+
+	mdns "github.com/miekg/dns"
+)
+
+// compareOwnerNames orders relative owner names with the apex first, followed by the other names in DNSSEC canonical
+// order (RFC 4034), which compares the labels from right to left so names are grouped by their parent
+func compareOwnerNames(a, b string) int {
+	if a == b {
+		return 0
+	}
+	if a == apexName {
+		return -1
+	}
+	if b == apexName {
+		return 1
+	}
+
+	aLabels := strings.Split(strings.ToLower(a), ".")
+	bLabels := strings.Split(strings.ToLower(b), ".")
+	for i := 1; i <= len(aLabels) && i <= len(bLabels); i++ {
+		if c := strings.Compare(aLabels[len(aLabels)-i], bLabels[len(bLabels)-i]); c != 0 {
+			return c
+		}
+	}
+
+	return len(aLabels) - len(bLabels)
+}
+
+// compareRData orders addresses numerically and all other RData as strings
+func compareRData(a, b string) int {
+	aIP, bIP := net.ParseIP(a), net.ParseIP(b)
+	if aIP != nil && bIP != nil {
+		return bytes.Compare(aIP.To16(), bIP.To16())
+	}
+
+	return strings.Compare(a, b)
+}
+
+// recordLess orders records by owner name, then by the numeric RR type, then by RData
+func recordLess(a, b resourceRecord) bool {
+	if c := compareOwnerNames(a.Name, b.Name); c != 0 {
+		return c < 0
+	}
+	if a.Type != b.Type {
+		return mdns.StringToType[string(a.Type)] < mdns.StringToType[string(b.Type)]
+	}
+	if c := compareRData(a.RData, b.RData); c != 0 {
+		return c < 0
+	}
+
+	return a.AddressID < b.AddressID
+}
+
+// sortZoneRecords sorts the records of every zone, so the generated zones do not depend on the order the addresses were
+// loaded in
+func sortZoneRecords(zones []Zone) {
+	for _, zone := range zones {
+		sort.SliceStable(zone.Records, func(i, j int) bool {
+			return recordLess(zone.Records[i], zone.Records[j])
+		})
+	}
+}
@@ -0,0 +1,46 @@
+package dns
+
+import (
+	"testing"
+
+	"github.com/go-test/deep"
+)
+
+func TestSortZoneRecords(t *testing.T) {
+	zones := []Zone{{Name: "example.com", Records: []resourceRecord{
+		{Name: "www", Type: CName, RData: "web"},
+		{Name: "web", Type: Aaaa, RData: "fd00::5"},
+		{Name: "web", Type: A, RData: "10.0.0.10"},
+		{Name: "ns1.lab", Type: A, RData: "10.0.0.53"},
+		{Name: "lab", Type: NS, RData: "ns1.lab"},
+		{Name: "web", Type: A, RData: "10.0.0.9"},
+		{Name: "*.apps", Type: A, RData: "10.0.0.8"},
+		{Name: "@", Type: A, RData: "10.0.0.7"},
+		{Name: "apps", Type: A, RData: "10.0.0.8"},
+	}}}
+
+	sortZoneRecords(zones)
+	expected := []resourceRecord{
+		{Name: "@", Type: A, RData: "10.0.0.7"},
+		{Name: "apps", Type: A, RData: "10.0.0.8"},
+		{Name: "*.apps", Type: A, RData: "10.0.0.8"},
+		{Name: "lab", Type: NS, RData: "ns1.lab"},
+		{Name: "ns1.lab", Type: A, RData: "10.0.0.53"},
+		{Name: "web", Type: A, RData: "10.0.0.9"},
+		{Name: "web", Type: A, RData: "10.0.0.10"},
+		{Name: "web", Type: Aaaa, RData: "fd00::5"},
+		{Name: "www", Type: CName, RData: "web"},
+	}
+	if diff := deep.Equal(zones[0].Records, expected); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestCompareOwnerNames(t *testing.T) {
+	ordered := []string{"@", "a", "b.a", "z.a", "B", "host.dept", "Web"}
+	for i := 0; i < len(ordered)-1; i++ {
+		if compareOwnerNames(ordered[i], ordered[i+1]) >= 0 {
+			t.Errorf("Expected <%s> before <%s>", ordered[i], ordered[i+1])
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package dns
+
+import (
+	"encoding/json"
+	"os"
+)
+
+const reportFile = "generated/dns-report.json"
+
+// generationReport is the machine-readable report of a zone generation run
+type generationReport struct {
+	GeneratedAt   string             `json:"generated_at"`
+	Conflicts     []recordConflict   `json:"conflicts"`
+	Sanitizations []nameSanitization `json:"sanitizations"`
+	DNSSEC        []dsReportEntry    `json:"dnssec"`
+}
+
+func writeReport(report generationReport) {
+	if report.Conflicts == nil {
+		report.Conflicts = []recordConflict{}
+	}
+	if report.Sanitizations == nil {
+		report.Sanitizations = []nameSanitization{}
+	}
+	if report.DNSSEC == nil {
+		report.DNSSEC = []dsReportEntry{}
+	}
+
+	reportBytes, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		panic(err)
+	}
+
+	err = os.WriteFile(reportFile, reportBytes, 0644)
+	if err != nil {
+		panic(err)
+	}
+}
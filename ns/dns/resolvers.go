@@ -0,0 +1,351 @@
+package dns
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+
+	mdns "github.com/miekg/dns"
+	"peg.nu/nx/cache"
+	"peg.nu/nx/config"
+	"peg.nu/nx/util"
+)
+
+type resolverFormat string
+
+const (
+	// formatCoreDNSHosts writes a hosts file for the CoreDNS hosts plugin
+	formatCoreDNSHosts resolverFormat = "coredns_hosts"
+	// formatCoreDNSFile writes a zone file per zone for the CoreDNS file plugin
+	formatCoreDNSFile resolverFormat = "coredns_file"
+	// formatUnbound writes local-zone and local-data statements
+	formatUnbound resolverFormat = "unbound"
+	// formatDnsmasq writes host-record, cname and ptr-record options
+	formatDnsmasq resolverFormat = "dnsmasq"
+)
+
+const defaultLocalZoneType = "transparent"
+
+type hostsEntry struct {
+	IP    string
+	Names []string
+}
+
+type unboundZone struct {
+	Name      string
+	Type      string
+	LocalData []string
+}
+
+type dnsmasqHostRecord struct {
+	Name      string
+	Addresses []string
+}
+
+type dnsmasqCName struct {
+	Alias  string
+	Target string
+}
+
+type dnsmasqPtrRecord struct {
+	Name   string
+	Target string
+}
+
+type resolverTemplateVars struct {
+	TargetName  string
+	GeneratedAt string
+
+	Hosts       []hostsEntry
+	LocalZones  []unboundZone
+	HostRecords []dnsmasqHostRecord
+	CNames      []dnsmasqCName
+	PtrRecords  []dnsmasqPtrRecord
+}
+
+// targetZones returns the zones selected by the target. Zones with views are only selected if the target uses the view.
+func targetZones(target config.ResolverTarget, zones []Zone) []Zone {
+	var selected []Zone
+	for _, zone := range zones {
+		if len(target.Zones) > 0 && !util.SliceContainsString(target.Zones, zone.Name) {
+			continue
+		}
+		if len(zone.View) > 0 && zone.View != target.View {
+			continue
+		}
+
+		selected = append(selected, zone)
+	}
+
+	return selected
+}
+
+// resolverRRs returns the records of all zones as fully qualified resource records
+func resolverRRs(zones []Zone) []mdns.RR {
+	var rrs []mdns.RR
+	for _, zone := range zones {
+		zoneRRs, err := zoneRRs(zone)
+		if err != nil {
+			panic(err)
+		}
+		rrs = append(rrs, withoutWildcards(zoneRRs)...)
+	}
+
+	return rrs
+}
+
+// withoutWildcards removes the wildcard records, which the resolver outputs can not express
+func withoutWildcards(rrs []mdns.RR) []mdns.RR {
+	var filtered []mdns.RR
+	for _, rr := range rrs {
+		if strings.HasPrefix(rr.Header().Name, "*.") {
+			logger.Printf("Skipping wildcard record %s, it can not be used for resolvers", strings.ReplaceAll(rr.String(), "\t", " "))
+			continue
+		}
+		filtered = append(filtered, rr)
+	}
+
+	return filtered
+}
+
+// addressOf returns the address of A and AAAA records, or nil for all other records
+func addressOf(rr mdns.RR) net.IP {
+	switch typed := rr.(type) {
+	case *mdns.A:
+		return typed.A
+	case *mdns.AAAA:
+		return typed.AAAA
+	}
+
+	return nil
+}
+
+// buildHostsEntries lists all addresses with their names. CNAMEs are left out, as the hosts plugin would also answer
+// PTR queries with them, which the zones do not publish.
+func buildHostsEntries(rrs []mdns.RR) []hostsEntry {
+	var entries []hostsEntry
+	entryIndexes := make(map[string]int)
+	skippedCNames := 0
+
+	for _, rr := range rrs {
+		if _, ok := rr.(*mdns.CNAME); ok {
+			skippedCNames++
+			continue
+		}
+
+		ip := addressOf(rr)
+		if ip == nil {
+			continue
+		}
+
+		idx, ok := entryIndexes[ip.String()]
+		if !ok {
+			idx = len(entries)
+			entryIndexes[ip.String()] = idx
+			entries = append(entries, hostsEntry{IP: ip.String()})
+		}
+		name := strings.TrimSuffix(strings.ToLower(rr.Header().Name), ".")
+		if !util.SliceContainsString(entries[idx].Names, name) {
+			entries[idx].Names = append(entries[idx].Names, name)
+		}
+	}
+
+	if skippedCNames > 0 {
+		logger.Printf("Skipping %d CNAME record(s) for hosts output, hosts files can not express CNAMEs", skippedCNames)
+	}
+
+	return entries
+}
+
+// delegationCuts returns the owners of the NS records below the apex of the zone
+func delegationCuts(rrs []mdns.RR, zone string) []string {
+	var cuts []string
+	for _, rr := range rrs {
+		owner := strings.ToLower(rr.Header().Name)
+		if rr.Header().Rrtype == mdns.TypeNS && owner != zone && !util.SliceContainsString(cuts, owner) {
+			cuts = append(cuts, owner)
+		}
+	}
+
+	return cuts
+}
+
+// isBelowCuts returns true if name is at or below one of the delegation cuts
+func isBelowCuts(name string, cuts []string) bool {
+	for _, cut := range cuts {
+		if mdns.IsSubDomain(cut, name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// buildUnboundZones converts the zones into local-zone and local-data statements. Delegations are not supported by
+// local-data and are skipped together with their glue.
+func buildUnboundZones(zones []Zone, localZoneType string) []unboundZone {
+	var localZones []unboundZone
+	for _, zone := range zones {
+		rrs, err := zoneRRs(zone)
+		if err != nil {
+			panic(err)
+		}
+
+		localZone := unboundZone{Name: mdns.Fqdn(zone.Name), Type: localZoneType}
+		cuts := delegationCuts(rrs, strings.ToLower(localZone.Name))
+		for _, cut := range cuts {
+			logger.Printf("Skipping delegation %s and its glue for unbound output, delegations are not supported by local-data", cut)
+		}
+
+		for _, rr := range withoutWildcards(rrs) {
+			if rr.Header().Rrtype == mdns.TypeNS || rr.Header().Rrtype == mdns.TypeDS || isBelowCuts(strings.ToLower(rr.Header().Name), cuts) {
+				continue
+			}
+
+			localData := strings.ReplaceAll(rr.String(), "\t", " ")
+			if !util.SliceContainsString(localZone.LocalData, localData) {
+				localZone.LocalData = append(localZone.LocalData, localData)
+			}
+		}
+		localZones = append(localZones, localZone)
+	}
+
+	return localZones
+}
+
+// buildDnsmasqRecords converts the records into dnsmasq options. host-record creates the PTR records of its addresses,
+// so ptr-record is only used for PTR records not covered by a host-record.
+func buildDnsmasqRecords(rrs []mdns.RR) ([]dnsmasqHostRecord, []dnsmasqCName, []dnsmasqPtrRecord) {
+	var hostRecords []dnsmasqHostRecord
+	hostIndexes := make(map[string]int)
+	impliedPtrs := make(map[string]bool)
+
+	for _, rr := range rrs {
+		ip := addressOf(rr)
+		if ip == nil {
+			continue
+		}
+
+		name := strings.TrimSuffix(strings.ToLower(rr.Header().Name), ".")
+		idx, ok := hostIndexes[name]
+		if !ok {
+			idx = len(hostRecords)
+			hostIndexes[name] = idx
+			hostRecords = append(hostRecords, dnsmasqHostRecord{Name: name})
+		}
+		if !util.SliceContainsString(hostRecords[idx].Addresses, ip.String()) {
+			hostRecords[idx].Addresses = append(hostRecords[idx].Addresses, ip.String())
+		}
+
+		reverseName, err := mdns.ReverseAddr(ip.String())
+		if err == nil {
+			impliedPtrs[strings.ToLower(reverseName)+" "+name] = true
+		}
+	}
+
+	var cnames []dnsmasqCName
+	var ptrRecords []dnsmasqPtrRecord
+	for _, rr := range rrs {
+		switch typed := rr.(type) {
+		case *mdns.CNAME:
+			cnames = append(cnames, dnsmasqCName{
+				Alias:  strings.TrimSuffix(strings.ToLower(typed.Hdr.Name), "."),
+				Target: strings.TrimSuffix(strings.ToLower(typed.Target), "."),
+			})
+		case *mdns.PTR:
+			target := strings.TrimSuffix(strings.ToLower(typed.Ptr), ".")
+			if impliedPtrs[strings.ToLower(typed.Hdr.Name)+" "+target] {
+				continue
+			}
+			ptrRecords = append(ptrRecords, dnsmasqPtrRecord{Name: strings.TrimSuffix(strings.ToLower(typed.Hdr.Name), "."), Target: target})
+		}
+	}
+
+	return hostRecords, cnames, ptrRecords
+}
+
+func loadResolverTemplate(name string) *cache.CachedTemplateWriter {
+	templateString, err := os.ReadFile(fmt.Sprintf("templates/%s.tmpl", name))
+	if err != nil {
+		panic(err)
+	}
+	resolverTemplate := template.Must(template.New(name).Parse(string(templateString)))
+	ignoreRegexes := []*regexp.Regexp{
+		regexp.MustCompile("(?m)^# Generated at .*$"),
+		regexp.MustCompile("(?m)^; Generated at .*$"),
+		regexp.MustCompile("(?m)^\\s+\\d+\\s+; serial.*$"),
+	}
+
+	return cache.New(resolverTemplate, ignoreRegexes, name == "bind-zone")
+}
+
+// GenerateResolverConfigs writes the records of the selected zones in the format of each configured resolver target
+func GenerateResolverConfigs(zones []Zone, conf *config.NXConfig) {
+	writers := make(map[resolverFormat]*cache.CachedTemplateWriter)
+	writer := func(format resolverFormat, templateName string) *cache.CachedTemplateWriter {
+		if _, ok := writers[format]; !ok {
+			writers[format] = loadResolverTemplate(templateName)
+		}
+		return writers[format]
+	}
+
+	generatedAt := time.Now().Format(time.RFC3339)
+	for _, target := range conf.Namespaces.DNS.Resolvers {
+		selectedZones := targetZones(target, zones)
+		templateVars := resolverTemplateVars{TargetName: target.Name, GeneratedAt: generatedAt}
+
+		var err error
+		switch resolverFormat(target.Format) {
+		case formatCoreDNSHosts:
+			templateVars.Hosts = buildHostsEntries(resolverRRs(selectedZones))
+			_, err = writer(formatCoreDNSHosts, "coredns-hosts").WriteTemplate(fmt.Sprintf("generated/resolvers/%s.hosts", target.Name), templateVars)
+		case formatCoreDNSFile:
+			for _, zone := range selectedZones {
+				if len(zone.Includes) > 0 {
+					logger.Printf("Includes of zone %s are ignored for resolver %s", zone.Name, target.Name)
+				}
+
+				_, err = writer(formatCoreDNSFile, "bind-zone").WriteTemplate(fmt.Sprintf("generated/resolvers/%s.%s.db", target.Name, zone.Name), templateArguments{
+					SOAInfo:     zone.SOAInfo,
+					Records:     zone.Records,
+					ZoneName:    zone.Name,
+					View:        zone.View,
+					GeneratedAt: generatedAt,
+				})
+				if err != nil {
+					break
+				}
+			}
+		case formatUnbound:
+			localZoneType := target.LocalZoneType
+			if len(localZoneType) == 0 {
+				localZoneType = defaultLocalZoneType
+			}
+			templateVars.LocalZones = buildUnboundZones(selectedZones, localZoneType)
+			_, err = writer(formatUnbound, "unbound").WriteTemplate(fmt.Sprintf("generated/resolvers/%s.conf", target.Name), templateVars)
+		case formatDnsmasq:
+			templateVars.HostRecords, templateVars.CNames, templateVars.PtrRecords = buildDnsmasqRecords(resolverRRs(selectedZones))
+			_, err = writer(formatDnsmasq, "dnsmasq").WriteTemplate(fmt.Sprintf("generated/resolvers/%s.dnsmasq.conf", target.Name), templateVars)
+		default:
+			logger.Printf("Unknown resolver format <%s> for target %s, skipping", target.Format, target.Name)
+		}
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	var processedFiles []string
+	for _, format := range []resolverFormat{formatCoreDNSHosts, formatCoreDNSFile, formatUnbound, formatDnsmasq} {
+		cw, ok := writers[format]
+		if !ok {
+			continue
+		}
+		processedFiles = append(processedFiles, cw.ProcessedFiles...)
+		conf.UpdatedFiles = append(conf.UpdatedFiles, cw.UpdatedFiles...)
+	}
+	util.CleanDirectoryExcept("generated/resolvers", processedFiles, conf)
+}
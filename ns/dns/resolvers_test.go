@@ -0,0 +1,84 @@
+package dns
+
+import (
+	"testing"
+
+	"github.com/go-test/deep"
+	"peg.nu/nx/config"
+)
+
+var resolverTestZones = []Zone{
+	{Name: "example.com", SOAInfo: SOAInfo{BindDefaultRRTTL: 120}, Records: []resourceRecord{
+		{Name: "web", Type: A, RData: "10.0.0.5"},
+		{Name: "web", Type: Aaaa, RData: "fd00::5"},
+		{Name: "www", Type: CName, RData: "web"},
+		{Name: "ext", Type: CName, RData: "www.example.net."},
+		{Name: "lab", Type: NS, RData: "ns1.lab"},
+		{Name: "ns1.lab", Type: A, RData: "10.0.0.53"},
+		// wildcards can not be expressed by the resolver outputs
+		{Name: "*.apps", Type: A, RData: "10.0.0.8"},
+	}},
+	{Name: "0.0.10.in-addr.arpa", SOAInfo: SOAInfo{BindDefaultRRTTL: 120}, Records: []resourceRecord{
+		{Name: "5", Type: Ptr, RData: "web.example.com."},
+		{Name: "6", Type: Ptr, RData: "printer.example.com."},
+	}},
+	{Name: "example.org", View: "external", SOAInfo: SOAInfo{BindDefaultRRTTL: 120}, Records: []resourceRecord{
+		{Name: "web", Type: A, RData: "192.0.2.5"},
+	}},
+}
+
+func TestTargetZones(t *testing.T) {
+	zones := targetZones(config.ResolverTarget{Zones: []string{"example.com", "example.org"}}, resolverTestZones)
+	if len(zones) != 1 || zones[0].Name != "example.com" {
+		t.Errorf("Expected only zone example.com without view; but was <%v>", ZoneNames(zones))
+	}
+
+	zones = targetZones(config.ResolverTarget{View: "external"}, resolverTestZones)
+	if diff := deep.Equal(ZoneNames(zones), []string{"example.com", "0.0.10.in-addr.arpa", "example.org"}); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestBuildHostsEntries(t *testing.T) {
+	// CNAMEs are left out, the hosts plugin would answer PTR queries with them
+	expected := []hostsEntry{
+		{IP: "10.0.0.5", Names: []string{"web.example.com"}},
+		{IP: "fd00::5", Names: []string{"web.example.com"}},
+		{IP: "10.0.0.53", Names: []string{"ns1.lab.example.com"}},
+	}
+
+	if diff := deep.Equal(buildHostsEntries(resolverRRs(resolverTestZones[:2])), expected); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestBuildUnboundZones(t *testing.T) {
+	// the delegation of lab is skipped together with its glue
+	expected := []unboundZone{
+		{Name: "example.com.", Type: "static", LocalData: []string{
+			"web.example.com. 120 IN A 10.0.0.5",
+			"web.example.com. 120 IN AAAA fd00::5",
+			"www.example.com. 120 IN CNAME web.example.com.",
+			"ext.example.com. 120 IN CNAME www.example.net.",
+		}},
+	}
+
+	if diff := deep.Equal(buildUnboundZones(resolverTestZones[:1], "static"), expected); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestBuildDnsmasqRecords(t *testing.T) {
+	hostRecords, cnames, ptrRecords := buildDnsmasqRecords(resolverRRs(resolverTestZones[:2]))
+
+	if diff := deep.Equal(hostRecords, []dnsmasqHostRecord{{Name: "web.example.com", Addresses: []string{"10.0.0.5", "fd00::5"}}, {Name: "ns1.lab.example.com", Addresses: []string{"10.0.0.53"}}}); diff != nil {
+		t.Error(diff)
+	}
+	if diff := deep.Equal(cnames, []dnsmasqCName{{Alias: "www.example.com", Target: "web.example.com"}, {Alias: "ext.example.com", Target: "www.example.net"}}); diff != nil {
+		t.Error(diff)
+	}
+	// the PTR of web is created by its host-record
+	if diff := deep.Equal(ptrRecords, []dnsmasqPtrRecord{{Name: "6.0.0.10.in-addr.arpa", Target: "printer.example.com"}}); diff != nil {
+		t.Error(diff)
+	}
+}
@@ -0,0 +1,241 @@
+package dns
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math/bits"
+	"net"
+	"strconv"
+	"strings"
+
+	"peg.nu/nx/config"
+	"peg.nu/nx/model"
+	"peg.nu/nx/util"
+)
+
+// reverseZone describes the reverse zone of a CIDR
+type reverseZone struct {
+	Name string
+	// ClasslessParent is the enclosing /24 zone if Name is an RFC 2317 classless zone, empty otherwise
+	ClasslessParent string
+	IsIP4           bool
+	Net             *net.IPNet
+}
+
+// parseReverseZone returns the reverse zone for the given CIDR. IPv4 prefixes must fall on an octet boundary or be longer
+// than /24, in which case an RFC 2317 classless zone like 0-31.2.0.192.in-addr.arpa is used. IPv6 prefixes must fall on a
+// nibble boundary.
+func parseReverseZone(cidr string) (reverseZone, error) {
+	_, ipNet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return reverseZone{}, err
+	}
+	prefixSize, bits := ipNet.Mask.Size()
+	isIP4 := bits == 32
+
+	if !isIP4 {
+		if prefixSize%4 != 0 {
+			return reverseZone{}, fmt.Errorf("reverse zone %s does not fall on a nibble boundary", cidr)
+		}
+
+		name, _, err := ipToNibble(ipNet.String(), true)
+		return reverseZone{Name: name, IsIP4: false, Net: ipNet}, err
+	}
+
+	if prefixSize%8 == 0 && prefixSize <= 24 {
+		name, _, err := ipToNibble(ipNet.String(), true)
+		return reverseZone{Name: name, IsIP4: true, Net: ipNet}, err
+	}
+
+	if prefixSize <= 24 || prefixSize == 32 {
+		return reverseZone{}, fmt.Errorf("reverse zone %s does not fall on an octet boundary and can not be delegated classless", cidr)
+	}
+
+	// RFC 2317 classless zone
+	parentNet := &net.IPNet{IP: ipNet.IP.Mask(net.CIDRMask(24, 32)), Mask: net.CIDRMask(24, 32)}
+	parent, _, err := ipToNibble(parentNet.String(), true)
+	if err != nil {
+		return reverseZone{}, err
+	}
+
+	ip4 := ipNet.IP.To4()
+	first := int(ip4[3])
+	last := first + (1 << uint(32-prefixSize)) - 1
+
+	return reverseZone{
+		Name:            fmt.Sprintf("%d-%d.%s", first, last, parent),
+		ClasslessParent: parent,
+		IsIP4:           true,
+		Net:             ipNet,
+	}, nil
+}
+
+// ptrName returns the owner name of the PTR record of address relative to the reverse zone
+func (z reverseZone) ptrName(address string) (string, error) {
+	ip, _, err := net.ParseCIDR(address)
+	if err != nil {
+		return "", err
+	}
+	if !z.Net.Contains(ip) {
+		return "", fmt.Errorf("address %s is not within reverse zone %s (%s)", address, z.Name, z.Net)
+	}
+
+	name, _, err := ipToNibble(address, false)
+	if err != nil {
+		return "", err
+	}
+
+	if len(z.ClasslessParent) > 0 {
+		return strings.TrimSuffix(name, "."+z.ClasslessParent), nil
+	}
+	return strings.TrimSuffix(name, "."+z.Name), nil
+}
+
+// reverseZoneToCIDR parses a reverse zone name like 168.192.in-addr.arpa or 0-31.2.0.192.in-addr.arpa into its CIDR
+func reverseZoneToCIDR(zone string) (*net.IPNet, error) {
+	zone = normalizeZoneName(zone)
+
+	if strings.HasSuffix(zone, ".in-addr.arpa") {
+		labels := util.ReverseSlice(strings.Split(strings.TrimSuffix(zone, ".in-addr.arpa"), "."))
+		if len(labels) > 4 {
+			return nil, fmt.Errorf("too many labels in reverse zone %s", zone)
+		}
+
+		ip := make(net.IP, 4)
+		prefixSize := 8 * len(labels)
+		for i, label := range labels {
+			if i == 3 && len(labels) == 4 {
+				// RFC 2317 classless zone
+				bounds := strings.Split(label, "-")
+				first, firstErr := strconv.Atoi(bounds[0])
+				if len(bounds) != 2 || firstErr != nil {
+					return nil, fmt.Errorf("invalid classless label %s in reverse zone %s", label, zone)
+				}
+				last, err := strconv.Atoi(bounds[1])
+				size := last - first + 1
+				if err != nil || first < 0 || last > 255 || size <= 1 || size&(size-1) != 0 || first%size != 0 {
+					return nil, fmt.Errorf("invalid classless label %s in reverse zone %s", label, zone)
+				}
+
+				ip[i] = byte(first)
+				prefixSize = 32 - bits.TrailingZeros(uint(size))
+				continue
+			}
+
+			octet, err := strconv.Atoi(label)
+			if err != nil || octet < 0 || octet > 255 {
+				return nil, fmt.Errorf("invalid label %s in reverse zone %s", label, zone)
+			}
+			ip[i] = byte(octet)
+		}
+
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(prefixSize, 32)}, nil
+	}
+
+	if strings.HasSuffix(zone, ".ip6.arpa") {
+		nibbles := util.ReverseSlice(strings.Split(strings.TrimSuffix(zone, ".ip6.arpa"), "."))
+		if len(nibbles) > 32 {
+			return nil, fmt.Errorf("too many labels in reverse zone %s", zone)
+		}
+
+		hexString := strings.Join(nibbles, "") + strings.Repeat("0", 32-len(nibbles))
+		ipBytes, err := hex.DecodeString(hexString)
+		if err != nil {
+			return nil, fmt.Errorf("invalid nibble in reverse zone %s", zone)
+		}
+
+		return &net.IPNet{IP: ipBytes, Mask: net.CIDRMask(4*len(nibbles), 128)}, nil
+	}
+
+	return nil, fmt.Errorf("%s is not a reverse zone", zone)
+}
+
+// autoReverseCandidates returns the reverse zones of all primaries that have automatic reverse zones enabled
+func autoReverseCandidates(conf *config.NXConfig) []*net.IPNet {
+	var candidates []*net.IPNet
+	for _, primary := range conf.Namespaces.DNS.Primaries {
+		if !primary.AutoReverseZones && !conf.Namespaces.DNS.AutoReverseZones {
+			continue
+		}
+
+		for _, zone := range primary.Zones {
+			if !strings.HasSuffix(zone, ".arpa") {
+				continue
+			}
+
+			ipNet, err := reverseZoneToCIDR(zone)
+			if err != nil {
+				logger.Printf("Ignoring zone %s for automatic reverse zones: %v", zone, err)
+				continue
+			}
+			candidates = append(candidates, ipNet)
+		}
+	}
+
+	return candidates
+}
+
+// deriveReverseZone returns the CIDR of the most specific candidate reverse zone containing the address. If no candidate
+// matches and roundPrefix is set, the smallest zone on an octet or nibble boundary that encloses the address and lies
+// within its prefix is used instead.
+func deriveReverseZone(address model.IPAddress, candidates []*net.IPNet, roundPrefix bool) (string, bool) {
+	ip, _, err := net.ParseCIDR(address.Address)
+	if err != nil {
+		return "", false
+	}
+
+	var best *net.IPNet
+	for _, candidate := range candidates {
+		if !candidate.Contains(ip) {
+			continue
+		}
+
+		candidateSize, _ := candidate.Mask.Size()
+		if best != nil {
+			if bestSize, _ := best.Mask.Size(); bestSize >= candidateSize {
+				continue
+			}
+		}
+		best = candidate
+	}
+	if best != nil {
+		return best.String(), true
+	}
+
+	if !roundPrefix || address.Prefix == nil {
+		return "", false
+	}
+
+	_, prefixNet, err := net.ParseCIDR(address.Prefix.Prefix)
+	if err != nil {
+		return "", false
+	}
+	prefixSize, bitCount := prefixNet.Mask.Size()
+	if prefixSize == 0 {
+		return "", false
+	}
+
+	// round up, rounding down would create zones far larger than the prefix
+	boundary := 4
+	if bitCount == 32 {
+		boundary = 8
+	}
+	prefixSize = (prefixSize + boundary - 1) / boundary * boundary
+	if bitCount == 32 && prefixSize > 24 {
+		prefixSize = 24
+	}
+
+	mask := net.CIDRMask(prefixSize, bitCount)
+	return (&net.IPNet{IP: ip.Mask(mask), Mask: mask}).String(), true
+}
+
+// autoReverseRounding returns true if the reverse zone of addresses in the forward zone may be derived by rounding their
+// prefix, which is enabled globally or by the primary of the forward zone
+func autoReverseRounding(forwardZone string, conf *config.NXConfig) bool {
+	if conf.Namespaces.DNS.AutoReverseZones {
+		return true
+	}
+
+	primary := util.FindPrimaryForZone(*conf, forwardZone)
+	return primary != nil && primary.AutoReverseZones
+}
@@ -0,0 +1,139 @@
+package dns
+
+import (
+	"net"
+	"peg.nu/nx/config"
+	"peg.nu/nx/model"
+	"testing"
+)
+
+func TestReverseZones(t *testing.T) {
+	t.Run("octet", func(t *testing.T) {
+		testReverseZone("192.168.0.0/16", "192.168.1.5/16", "168.192.in-addr.arpa", "5.1", "", t)
+	})
+	t.Run("slash24", func(t *testing.T) {
+		testReverseZone("192.0.2.0/24", "192.0.2.5/24", "2.0.192.in-addr.arpa", "5", "", t)
+	})
+	t.Run("classless", func(t *testing.T) {
+		testReverseZone("192.0.2.0/27", "192.0.2.5/27", "0-31.2.0.192.in-addr.arpa", "5", "2.0.192.in-addr.arpa", t)
+	})
+	t.Run("classless-offset", func(t *testing.T) {
+		testReverseZone("192.0.2.70/26", "192.0.2.70/26", "64-127.2.0.192.in-addr.arpa", "70", "2.0.192.in-addr.arpa", t)
+	})
+	t.Run("nibble", func(t *testing.T) {
+		testReverseZone("2001:db8::/32", "2001:db8::1/64", "8.b.d.0.1.0.0.2.ip6.arpa", "1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0", "", t)
+	})
+}
+
+func TestInvalidReverseZones(t *testing.T) {
+	for _, cidr := range []string{"10.0.0.0/12", "10.0.0.0/23", "10.0.0.1/32", "2001:db8::/33", "not-a-cidr"} {
+		if _, err := parseReverseZone(cidr); err == nil {
+			t.Errorf("Expected reverse zone <%s> to be rejected", cidr)
+		}
+	}
+
+	zone, _ := parseReverseZone("192.0.2.0/27")
+	if _, err := zone.ptrName("192.0.2.40/24"); err == nil {
+		t.Errorf("Expected address outside of reverse zone to be rejected")
+	}
+}
+
+func testReverseZone(cidr, address, expectZone, expectName, expectParent string, t *testing.T) {
+	zone, err := parseReverseZone(cidr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if zone.Name != expectZone {
+		t.Errorf("Expected Zone to be <%s>; but was <%s>", expectZone, zone.Name)
+	}
+	if zone.ClasslessParent != expectParent {
+		t.Errorf("Expected Parent to be <%s>; but was <%s>", expectParent, zone.ClasslessParent)
+	}
+
+	name, err := zone.ptrName(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != expectName {
+		t.Errorf("Expected Name to be <%s>; but was <%s>", expectName, name)
+	}
+}
+
+func TestReverseZoneToCIDR(t *testing.T) {
+	expectations := map[string]string{
+		"168.192.in-addr.arpa":                     "192.168.0.0/16",
+		"0-31.2.0.192.in-addr.arpa.":               "192.0.2.0/27",
+		"f.f.f.f.f.f.f.f.f.f.f.f.ip6.arpa":         "ffff:ffff:ffff::/48",
+		"8.b.d.0.1.0.0.2.ip6.arpa":                 "2001:db8::/32",
+		"10.in-addr.arpa":                          "10.0.0.0/8",
+		"64-127.2.0.192.in-addr.arpa":              "192.0.2.64/26",
+		"1.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa":         "2001:db8:1::/48",
+		"0.0.0.0.1.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa": "2001:db8:1::/64",
+	}
+	for zone, expected := range expectations {
+		ipNet, err := reverseZoneToCIDR(zone)
+		if err != nil {
+			t.Errorf("Could not parse reverse zone <%s>: %v", zone, err)
+			continue
+		}
+		if ipNet.String() != expected {
+			t.Errorf("Expected CIDR of <%s> to be <%s>; but was <%s>", zone, expected, ipNet.String())
+		}
+	}
+
+	for _, zone := range []string{"example.com", "0-30.2.0.192.in-addr.arpa", "300.in-addr.arpa", "x.ip6.arpa"} {
+		if _, err := reverseZoneToCIDR(zone); err == nil {
+			t.Errorf("Expected reverse zone <%s> to be rejected", zone)
+		}
+	}
+}
+
+func TestDeriveReverseZone(t *testing.T) {
+	var candidates []*net.IPNet
+	for _, zone := range []string{"168.192.in-addr.arpa", "1.168.192.in-addr.arpa", "8.b.d.0.1.0.0.2.ip6.arpa"} {
+		ipNet, _ := reverseZoneToCIDR(zone)
+		candidates = append(candidates, ipNet)
+	}
+
+	expectations := []struct {
+		address, prefix string
+		round           bool
+		expected        string
+	}{
+		{"192.168.1.5/24", "192.168.1.0/24", false, "192.168.1.0/24"},
+		{"192.168.2.5/24", "192.168.2.0/24", false, "192.168.0.0/16"},
+		{"2001:db8::1/64", "2001:db8::/64", false, "2001:db8::/32"},
+		{"10.1.2.3/27", "10.1.2.0/27", false, ""},
+		{"10.1.2.3/27", "10.1.2.0/27", true, "10.1.2.0/24"},
+		{"10.1.2.3/20", "10.1.0.0/20", true, "10.1.2.0/24"},
+		{"10.1.2.3/8", "10.0.0.0/8", true, "10.0.0.0/8"},
+		{"2001:db9::1/64", "2001:db9::/62", true, "2001:db9::/64"},
+	}
+	for _, e := range expectations {
+		address := model.IPAddress{Address: e.address, Prefix: &model.IPAMPrefix{Prefix: e.prefix}}
+		derived, _ := deriveReverseZone(address, candidates, e.round)
+		if derived != e.expected {
+			t.Errorf("Expected derived reverse zone of <%s> to be <%s>; but was <%s>", e.address, e.expected, derived)
+		}
+	}
+}
+
+func TestAutoReverseRounding(t *testing.T) {
+	conf := &config.NXConfig{Namespaces: config.NamespaceConfig{DNS: config.DNSNamespaceConfig{Primaries: []config.PrimaryConfig{
+		{Name: "ns1", Zones: []string{"example.com"}, AutoReverseZones: true},
+		{Name: "ns2", Zones: []string{"example.net"}},
+	}}}}
+
+	expectations := map[string]bool{"example.com": true, "example.net": false, "": false}
+	for zone, expected := range expectations {
+		if actual := autoReverseRounding(zone, conf); actual != expected {
+			t.Errorf("Expected rounding <%v> for zone <%s>; but was <%v>", expected, zone, actual)
+		}
+	}
+
+	conf.Namespaces.DNS.AutoReverseZones = true
+	if !autoReverseRounding("example.net", conf) {
+		t.Errorf("Expected the global option to enable rounding for all zones")
+	}
+}
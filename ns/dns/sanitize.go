@@ -0,0 +1,190 @@
+package dns
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/idna"
+)
+
+type hostnamePolicy string
+
+const (
+	// policyReplace replaces invalid characters and truncates overlong labels
+	policyReplace hostnamePolicy = "replace"
+	// policyDrop skips names that are not valid hostnames
+	policyDrop hostnamePolicy = "drop"
+	// policyFail fails the generation if any name is not a valid hostname
+	policyFail hostnamePolicy = "fail"
+)
+
+func parseHostnamePolicy(policy string) hostnamePolicy {
+	switch hostnamePolicy(policy) {
+	case policyReplace, policyDrop, policyFail:
+		return hostnamePolicy(policy)
+	case "":
+		return policyReplace
+	}
+
+	logger.Printf("Unknown hostname policy <%s>, using %s", policy, policyReplace)
+	return policyReplace
+}
+
+type sanitizationAction string
+
+const (
+	actionReplaced sanitizationAction = "replaced"
+	actionDropped  sanitizationAction = "dropped"
+	actionFailed   sanitizationAction = "failed"
+)
+
+type nameSanitization struct {
+	AddressID int                `json:"netbox_address_id"`
+	Original  string             `json:"original"`
+	Sanitized string             `json:"sanitized,omitempty"`
+	Action    sanitizationAction `json:"action"`
+	Reasons   []string           `json:"reasons"`
+}
+
+const maxLabelLength = 63
+const maxNameLength = 253
+
+// invalidHostnameCharsRegex matches the characters that are neither valid in an RFC 1123 hostname nor in the Unicode
+// form of an internationalized label
+var invalidHostnameCharsRegex = regexp.MustCompile("[^\\p{L}\\p{M}\\p{N}-]+")
+
+// sanitizeHostname converts a name relative to zone into a valid RFC 1123 hostname. Invalid characters are replaced
+// before internationalized labels are converted to punycode. The returned reasons describe all changes, ok is false if
+// the name can not be fixed.
+func sanitizeHostname(name, zone string) (sanitized string, reasons []string, ok bool) {
+	if name == apexName {
+		return name, nil, true
+	}
+
+	var labels []string
+	for _, label := range strings.Split(strings.ToLower(name), ".") {
+		if len(label) == 0 {
+			reasons = append(reasons, "removed empty label")
+			continue
+		}
+
+		if invalidHostnameCharsRegex.MatchString(label) {
+			replaced := invalidHostnameCharsRegex.ReplaceAllString(label, "-")
+			reasons = append(reasons, fmt.Sprintf("replaced invalid characters in label %s", label))
+			label = replaced
+		}
+
+		label = trimHyphens(label, &reasons)
+		if len(label) == 0 {
+			reasons = append(reasons, "removed empty label")
+			continue
+		}
+
+		isALabel := strings.HasPrefix(label, "xn--")
+		if !isASCII(label) {
+			asciiLabel, err := idna.Lookup.ToASCII(label)
+			if err != nil {
+				reasons = append(reasons, fmt.Sprintf("could not convert label %s to punycode: %v", label, err))
+				return "", reasons, false
+			}
+
+			reasons = append(reasons, fmt.Sprintf("converted label %s to punycode %s", label, asciiLabel))
+			label = asciiLabel
+			isALabel = true
+		}
+
+		if len(label) > maxLabelLength {
+			// truncating a punycode label would make it undecodable
+			if isALabel {
+				reasons = append(reasons, fmt.Sprintf("punycode label %s is longer than %d characters", label, maxLabelLength))
+				return "", reasons, false
+			}
+
+			reasons = append(reasons, fmt.Sprintf("truncated label %s to %d characters", label, maxLabelLength))
+			label = trimHyphens(label[:maxLabelLength], &reasons)
+		}
+
+		labels = append(labels, label)
+	}
+
+	if len(labels) == 0 {
+		reasons = append(reasons, "no valid labels left")
+		return "", reasons, false
+	}
+
+	sanitized = strings.Join(labels, ".")
+	if len(sanitized)+len(zone)+1 > maxNameLength {
+		reasons = append(reasons, fmt.Sprintf("name %s.%s is longer than %d characters", sanitized, zone, maxNameLength))
+		return "", reasons, false
+	}
+
+	return sanitized, reasons, true
+}
+
+// trimHyphens removes the leading and trailing hyphens of the label, which are not allowed in hostnames
+func trimHyphens(label string, reasons *[]string) string {
+	trimmed := strings.Trim(label, "-")
+	if trimmed != label {
+		*reasons = append(*reasons, fmt.Sprintf("removed leading or trailing hyphens of label %s", label))
+	}
+
+	return trimmed
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+
+	return true
+}
+
+// applyHostnamePolicy sanitizes the name according to the policy and records the change. The returned name is empty if
+// the record must be skipped.
+func applyHostnamePolicy(name, zone string, addressID int, policy hostnamePolicy, sanitizations *[]nameSanitization) string {
+	sanitized, reasons, ok := sanitizeHostname(name, zone)
+	if len(reasons) == 0 {
+		return sanitized
+	}
+
+	sanitization := nameSanitization{AddressID: addressID, Original: name, Reasons: reasons}
+	switch {
+	case policy == policyFail:
+		sanitization.Action = actionFailed
+		sanitized = ""
+	case policy == policyDrop || !ok:
+		sanitization.Action = actionDropped
+		sanitized = ""
+	default:
+		sanitization.Action = actionReplaced
+		sanitization.Sanitized = sanitized
+	}
+
+	logger.Printf("Hostname %s of address %d %s: %s", name, addressID, sanitization.Action, strings.Join(reasons, ", "))
+	*sanitizations = append(*sanitizations, sanitization)
+	return sanitized
+}
+
+// sanitizeAddress applies the hostname policy to the name and the CNAMEs of the address. It returns false if the address
+// must be skipped.
+func sanitizeAddress(address *DNSIP, policy hostnamePolicy, sanitizations *[]nameSanitization) bool {
+	name := applyHostnamePolicy(address.IP.GetName(), address.ForwardZoneName, address.IP.ID, policy, sanitizations)
+	if len(name) == 0 {
+		return false
+	}
+	address.IP.DnsName = name
+
+	var cnames []string
+	for _, cname := range address.CNames {
+		if sanitized := applyHostnamePolicy(cname, address.ForwardZoneName, address.IP.ID, policy, sanitizations); len(sanitized) > 0 {
+			cnames = append(cnames, sanitized)
+		}
+	}
+	address.CNames = cnames
+
+	return true
+}
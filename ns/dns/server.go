@@ -0,0 +1,649 @@
+package dns
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	mdns "github.com/miekg/dns"
+	"peg.nu/nx/config"
+	"peg.nu/nx/util"
+)
+
+// outputServe serves the zones from the built-in authoritative nameserver
+const outputServe outputMode = "serve"
+
+const defaultServerListen = ":53"
+const defaultRefreshInterval = 5 * time.Minute
+
+// maxZoneHistory is the number of changes kept per zone to answer IXFR requests
+const maxZoneHistory = 16
+
+// maxTransferChunk is the number of records sent per message of a zone transfer
+const maxTransferChunk = 500
+
+// maxCNAMEChain limits the number of CNAMEs followed within a zone
+const maxCNAMEChain = 8
+
+// serverUDPSize is the EDNS buffer size announced by the server
+const serverUDPSize = 1232
+
+// zoneChange contains the records removed and added between two versions of a zone
+type zoneChange struct {
+	From    *mdns.SOA
+	To      *mdns.SOA
+	Removed []mdns.RR
+	Added   []mdns.RR
+}
+
+// servedZone is an immutable version of a zone served by the Server
+type servedZone struct {
+	Name string
+	SOA  *mdns.SOA
+	// Records contains all records except the SOA
+	Records []mdns.RR
+	// Owners contains all records including the SOA by lowercase owner name
+	Owners map[string][]mdns.RR
+	// History contains the previous changes, oldest first, ending at the current SOA
+	History     []zoneChange
+	Secondaries []servedSecondary
+}
+
+// servedSecondary is a nameserver the zone is transferred to
+type servedSecondary struct {
+	Address string
+	// Name is the nameserver name of the secondary, empty if it is not known
+	Name string
+	// Key is the TSIG key the secondary signs transfer requests with, empty if they are not signed
+	Key string
+}
+
+// Server is an authoritative nameserver for the zones of all primaries with the serve output
+type Server struct {
+	conf   *config.NXConfig
+	client *mdns.Client
+	// transferKeys are the transfer keys of all primaries and secondaries by key name
+	transferKeys map[string]config.TSIGKey
+	// tsigSecrets are the secrets of all transfer keys by fully qualified key name
+	tsigSecrets map[string]string
+
+	mu    sync.RWMutex
+	zones map[string]*servedZone
+}
+
+func NewServer(conf *config.NXConfig) *Server {
+	transferKeys := resolveTransferKeys(conf)
+	tsigSecrets := make(map[string]string)
+	for name, key := range transferKeys {
+		tsigSecrets[mdns.Fqdn(name)] = key.Secret
+	}
+
+	return &Server{
+		conf:         conf,
+		client:       &mdns.Client{Net: "udp", Timeout: 5 * time.Second, TsigSecret: tsigSecrets},
+		transferKeys: transferKeys,
+		tsigSecrets:  tsigSecrets,
+		zones:        make(map[string]*servedZone),
+	}
+}
+
+// ServerListenAddress returns the configured listen address of the built-in nameserver
+func ServerListenAddress(conf *config.NXConfig) string {
+	if len(conf.Namespaces.DNS.Server.Listen) == 0 {
+		return defaultServerListen
+	}
+
+	return conf.Namespaces.DNS.Server.Listen
+}
+
+// ServerRefreshInterval returns the configured interval between two generations in server mode
+func ServerRefreshInterval(conf *config.NXConfig) time.Duration {
+	if conf.Namespaces.DNS.Server.RefreshInterval <= 0 {
+		return defaultRefreshInterval
+	}
+
+	return time.Duration(conf.Namespaces.DNS.Server.RefreshInterval) * time.Second
+}
+
+// ListenAndServe serves DNS over UDP and TCP on the address until one of the listeners fails
+func (s *Server) ListenAndServe(address string) error {
+	errs := make(chan error, 2)
+	for _, network := range []string{"udp", "tcp"} {
+		server := &mdns.Server{Addr: address, Net: network, Handler: s, TsigSecret: s.tsigSecrets}
+		go func() {
+			errs <- server.ListenAndServe()
+		}()
+	}
+
+	return <-errs
+}
+
+// secondaryAddress returns the host and port of a secondary
+func secondaryAddress(secondary config.AdditionalSecondary) string {
+	port := secondary.Port
+	if port == 0 {
+		port = 53
+	}
+
+	return net.JoinHostPort(secondary.IP, strconv.Itoa(port))
+}
+
+// zoneSecondaries returns all other primaries and the additional secondaries of the zone. The other primaries sign
+// their requests with the transfer key of the primary like for BIND primaries.
+func zoneSecondaries(primary *config.PrimaryConfig, zone string, conf *config.NXConfig) []servedSecondary {
+	var secondaries []servedSecondary
+	for _, other := range conf.Namespaces.DNS.Primaries {
+		if other.Name == primary.Name {
+			continue
+		}
+
+		port := other.Port
+		if port == 0 {
+			port = 53
+		}
+		secondaries = append(secondaries, servedSecondary{
+			Address: net.JoinHostPort(other.IP, strconv.Itoa(port)),
+			Name:    other.Name,
+			Key:     primary.TransferKey,
+		})
+	}
+
+	for _, secondary := range primary.AdditionalSecondaries[zone] {
+		secondaries = append(secondaries, servedSecondary{Address: secondaryAddress(secondary), Name: secondary.Name, Key: secondary.Key})
+	}
+
+	return secondaries
+}
+
+// zoneSOA returns the SOA record of the zone
+func zoneSOA(zone Zone) (*mdns.SOA, error) {
+	serial, err := strconv.ParseUint(zone.SOAInfo.Serial, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid serial %s of zone %s: %w", zone.SOAInfo.Serial, zone.Name, err)
+	}
+
+	return &mdns.SOA{
+		Hdr:     mdns.RR_Header{Name: mdns.Fqdn(strings.ToLower(zone.Name)), Rrtype: mdns.TypeSOA, Class: mdns.ClassINET, Ttl: uint32(zone.SOAInfo.BindDefaultRRTTL)},
+		Ns:      zone.SOAInfo.NameserverFQDN,
+		Mbox:    mdns.Fqdn(zone.SOAInfo.DottedMailResponsible),
+		Serial:  uint32(serial),
+		Refresh: uint32(zone.SOAInfo.Refresh),
+		Retry:   uint32(zone.SOAInfo.Retry),
+		Expire:  uint32(zone.SOAInfo.Expire),
+		Minttl:  uint32(zone.SOAInfo.TTL),
+	}, nil
+}
+
+func rrKey(rr mdns.RR) string {
+	return strings.ToLower(rr.String())
+}
+
+// rrDifference returns the records of a that are not in b
+func rrDifference(a, b []mdns.RR) []mdns.RR {
+	bKeys := make(map[string]bool, len(b))
+	for _, rr := range b {
+		bKeys[rrKey(rr)] = true
+	}
+
+	var difference []mdns.RR
+	for _, rr := range a {
+		if !bKeys[rrKey(rr)] {
+			difference = append(difference, rr)
+		}
+	}
+
+	return difference
+}
+
+// apexNameservers returns the NS records of the zone apex, the primary and all secondaries with a known name
+func apexNameservers(soa *mdns.SOA, secondaries []servedSecondary) []mdns.RR {
+	names := []string{soa.Ns}
+	for _, secondary := range secondaries {
+		if len(secondary.Name) > 0 {
+			names = append(names, mdns.Fqdn(strings.ToLower(secondary.Name)))
+		}
+	}
+
+	var records []mdns.RR
+	for _, name := range names {
+		records = append(records, &mdns.NS{
+			Hdr: mdns.RR_Header{Name: soa.Hdr.Name, Rrtype: mdns.TypeNS, Class: mdns.ClassINET, Ttl: soa.Hdr.Ttl},
+			Ns:  name,
+		})
+	}
+
+	return records
+}
+
+// newServedZone builds the next version of the zone. The serial of the previous version is kept if the records did not
+// change and increased if the generated serial is not newer.
+func newServedZone(zone Zone, previous *servedZone, secondaries []servedSecondary) (*servedZone, error) {
+	soa, err := zoneSOA(zone)
+	if err != nil {
+		return nil, err
+	}
+
+	records, err := zoneRRs(zone)
+	if err != nil {
+		return nil, err
+	}
+	records = append(apexNameservers(soa, secondaries), records...)
+
+	var unique []mdns.RR
+	seen := make(map[string]bool)
+	for _, rr := range records {
+		if !seen[rrKey(rr)] {
+			seen[rrKey(rr)] = true
+			unique = append(unique, rr)
+		}
+	}
+
+	served := &servedZone{Name: soa.Hdr.Name, SOA: soa, Records: unique, Secondaries: secondaries}
+	if previous != nil {
+		removed := rrDifference(previous.Records, unique)
+		added := rrDifference(unique, previous.Records)
+		unchangedSOA := mdns.Copy(soa).(*mdns.SOA)
+		unchangedSOA.Serial = previous.SOA.Serial
+		soaChanged := rrKey(unchangedSOA) != rrKey(previous.SOA)
+
+		if !soaChanged && len(removed) == 0 && len(added) == 0 {
+			served.SOA = previous.SOA
+			served.History = previous.History
+		} else {
+			if !isNewerSerial(soa.Serial, previous.SOA.Serial) {
+				soa.Serial = previous.SOA.Serial + 1
+			}
+			served.History = append(append([]zoneChange{}, previous.History...), zoneChange{From: previous.SOA, To: soa, Removed: removed, Added: added})
+			if len(served.History) > maxZoneHistory {
+				served.History = served.History[len(served.History)-maxZoneHistory:]
+			}
+		}
+	}
+
+	served.Owners = make(map[string][]mdns.RR)
+	for _, rr := range append([]mdns.RR{served.SOA}, served.Records...) {
+		owner := strings.ToLower(rr.Header().Name)
+		served.Owners[owner] = append(served.Owners[owner], rr)
+	}
+
+	return served, nil
+}
+
+// isNewerSerial compares serials using RFC 1982 serial number arithmetic
+func isNewerSerial(serial, than uint32) bool {
+	return serial != than && serial-than < 1<<31
+}
+
+// Update replaces the served zones with the generated zones of all primaries with the serve output and notifies the
+// secondaries of all changed zones
+func (s *Server) Update(zones []Zone) error {
+	s.mu.RLock()
+	previousZones := s.zones
+	s.mu.RUnlock()
+
+	var errs []error
+	updatedZones := make(map[string]*servedZone)
+	var changed []*servedZone
+	for _, zone := range zones {
+		primary := util.FindPrimaryForZone(*s.conf, zone.Name)
+		if primaryOutput(primary) != outputServe {
+			continue
+		}
+		if len(zone.View) > 0 {
+			logger.Printf("Views are not supported by the built-in nameserver, skipping zone %s in view %s", zone.Name, zone.View)
+			continue
+		}
+		if len(zone.Includes) > 0 {
+			logger.Printf("Includes of zone %s are ignored by the built-in nameserver", zone.Name)
+		}
+
+		previous := previousZones[mdns.Fqdn(strings.ToLower(zone.Name))]
+		served, err := newServedZone(zone, previous, zoneSecondaries(primary, zone.Name, s.conf))
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		updatedZones[served.Name] = served
+		if previous == nil || previous.SOA.Serial != served.SOA.Serial {
+			changed = append(changed, served)
+		}
+	}
+
+	s.mu.Lock()
+	s.zones = updatedZones
+	s.mu.Unlock()
+
+	for _, zone := range changed {
+		logger.Printf("Serving zone %s with serial %d\n", zone.Name, zone.SOA.Serial)
+		for _, secondary := range zone.Secondaries {
+			go s.notify(zone, secondary)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// notify sends a NOTIFY for the zone to the secondary, signed with the key of the secondary if it has one
+func (s *Server) notify(zone *servedZone, secondary servedSecondary) {
+	m := new(mdns.Msg)
+	m.SetNotify(zone.Name)
+	m.Answer = []mdns.RR{zone.SOA}
+	if len(secondary.Key) > 0 {
+		m.SetTsig(mdns.Fqdn(secondary.Key), tsigAlgorithm(s.transferKeys[secondary.Key].Algorithm), 300, time.Now().Unix())
+	}
+
+	response, _, err := s.client.Exchange(m, secondary.Address)
+	if err != nil {
+		logger.Printf("Could not notify %s about zone %s: %v", secondary.Address, zone.Name, err)
+		return
+	}
+	if response.Rcode != mdns.RcodeSuccess {
+		logger.Printf("Notify of %s about zone %s failed: %s", secondary.Address, zone.Name, mdns.RcodeToString[response.Rcode])
+	}
+}
+
+// findZone returns the most specific served zone containing the name
+func (s *Server) findZone(name string) *servedZone {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var best *servedZone
+	for zoneName, zone := range s.zones {
+		if mdns.IsSubDomain(zoneName, name) && (best == nil || mdns.CountLabel(zoneName) > mdns.CountLabel(best.Name)) {
+			best = zone
+		}
+	}
+
+	return best
+}
+
+func (s *Server) ServeDNS(w mdns.ResponseWriter, r *mdns.Msg) {
+	m := new(mdns.Msg)
+	if r.Opcode != mdns.OpcodeQuery {
+		m.SetRcode(r, mdns.RcodeNotImplemented)
+		_ = w.WriteMsg(m)
+		return
+	}
+	if len(r.Question) != 1 {
+		m.SetRcode(r, mdns.RcodeFormatError)
+		_ = w.WriteMsg(m)
+		return
+	}
+
+	question := r.Question[0]
+	zone := s.findZone(strings.ToLower(question.Name))
+	if zone == nil {
+		m.SetRcode(r, mdns.RcodeRefused)
+		_ = w.WriteMsg(m)
+		return
+	}
+
+	if question.Qtype == mdns.TypeAXFR || question.Qtype == mdns.TypeIXFR {
+		s.transfer(w, r, zone)
+		return
+	}
+
+	m.SetReply(r)
+	zone.answer(m, question)
+
+	size := mdns.MinMsgSize
+	if opt := r.IsEdns0(); opt != nil {
+		size = min(max(int(opt.UDPSize()), mdns.MinMsgSize), serverUDPSize)
+		m.SetEdns0(serverUDPSize, false)
+	}
+	if w.LocalAddr().Network() == "udp" {
+		m.Truncate(size)
+	}
+	_ = w.WriteMsg(m)
+}
+
+// delegationFor returns the owner of the delegation above or at name, or an empty string if name is not delegated.
+// DS records at the delegation point belong to the parent zone.
+func (z *servedZone) delegationFor(name string, qtype uint16) string {
+	labels := mdns.SplitDomainName(name)
+	zoneLabels := mdns.CountLabel(z.Name)
+
+	for i := len(labels) - zoneLabels - 1; i >= 0; i-- {
+		candidate := mdns.Fqdn(strings.Join(labels[i:], "."))
+		if candidate == name && qtype == mdns.TypeDS {
+			continue
+		}
+
+		for _, rr := range z.Owners[candidate] {
+			if rr.Header().Rrtype == mdns.TypeNS {
+				return candidate
+			}
+		}
+	}
+
+	return ""
+}
+
+// negativeSOA returns the SOA for negative answers with the TTL limited to the negative caching time
+func (z *servedZone) negativeSOA() mdns.RR {
+	soa := mdns.Copy(z.SOA).(*mdns.SOA)
+	soa.Hdr.Ttl = min(soa.Hdr.Ttl, soa.Minttl)
+	return soa
+}
+
+func (z *servedZone) isEmptyNonTerminal(name string) bool {
+	for owner := range z.Owners {
+		if strings.HasSuffix(owner, "."+name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// wildcardRecords returns the records of the wildcard at the closest encloser of the name, synthesized with the name as
+// owner as defined in RFC 4592. ok is false if the closest encloser has no wildcard.
+func (z *servedZone) wildcardRecords(name string) ([]mdns.RR, bool) {
+	labels := mdns.SplitDomainName(name)
+	zoneLabels := mdns.CountLabel(z.Name)
+
+	// the closest encloser is the longest existing ancestor of the name, the apex always exists
+	for i := 1; i <= len(labels)-zoneLabels; i++ {
+		encloser := mdns.Fqdn(strings.Join(labels[i:], "."))
+		if _, exists := z.Owners[encloser]; !exists && !z.isEmptyNonTerminal(encloser) {
+			continue
+		}
+
+		wildcard, ok := z.Owners["*."+encloser]
+		if !ok {
+			return nil, false
+		}
+
+		synthesized := make([]mdns.RR, 0, len(wildcard))
+		for _, rr := range wildcard {
+			rr = mdns.Copy(rr)
+			rr.Header().Name = name
+			synthesized = append(synthesized, rr)
+		}
+		return synthesized, true
+	}
+
+	return nil, false
+}
+
+// answer fills the answer to the question, following CNAMEs within the zone
+func (z *servedZone) answer(m *mdns.Msg, question mdns.Question) {
+	name := strings.ToLower(question.Name)
+
+	if cut := z.delegationFor(name, question.Qtype); len(cut) > 0 {
+		for _, rr := range z.Owners[cut] {
+			ns, ok := rr.(*mdns.NS)
+			if !ok {
+				continue
+			}
+
+			m.Ns = append(m.Ns, ns)
+			for _, glue := range z.Owners[strings.ToLower(ns.Ns)] {
+				if glue.Header().Rrtype == mdns.TypeA || glue.Header().Rrtype == mdns.TypeAAAA {
+					m.Extra = append(m.Extra, glue)
+				}
+			}
+		}
+		return
+	}
+
+	m.Authoritative = true
+	for i := 0; i < maxCNAMEChain; i++ {
+		rrs, exists := z.Owners[name]
+		emptyNonTerminal := !exists && z.isEmptyNonTerminal(name)
+		if !exists && !emptyNonTerminal {
+			rrs, exists = z.wildcardRecords(name)
+		}
+		if !exists {
+			if !emptyNonTerminal {
+				m.Rcode = mdns.RcodeNameError
+			}
+			m.Ns = append(m.Ns, z.negativeSOA())
+			return
+		}
+
+		var cname *mdns.CNAME
+		var matching []mdns.RR
+		for _, rr := range rrs {
+			if rr.Header().Rrtype == question.Qtype || question.Qtype == mdns.TypeANY {
+				matching = append(matching, rr)
+			}
+			if rr.Header().Rrtype == mdns.TypeCNAME {
+				cname = rr.(*mdns.CNAME)
+			}
+		}
+
+		if len(matching) > 0 {
+			m.Answer = append(m.Answer, matching...)
+			return
+		}
+		if cname == nil {
+			m.Ns = append(m.Ns, z.negativeSOA())
+			return
+		}
+
+		m.Answer = append(m.Answer, cname)
+		target := strings.ToLower(cname.Target)
+		if !mdns.IsSubDomain(z.Name, target) || len(z.delegationFor(target, question.Qtype)) > 0 {
+			return
+		}
+		name = target
+	}
+}
+
+// allowsTransfer returns true if the request comes from one of the secondaries of the zone. Requests of secondaries
+// with a key must be signed with it.
+func (z *servedZone) allowsTransfer(w mdns.ResponseWriter, r *mdns.Msg) bool {
+	host, _, err := net.SplitHostPort(w.RemoteAddr().String())
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+
+	for _, secondary := range z.Secondaries {
+		secondaryHost, _, err := net.SplitHostPort(secondary.Address)
+		if err != nil || ip == nil || !ip.Equal(net.ParseIP(secondaryHost)) {
+			continue
+		}
+		if len(secondary.Key) == 0 {
+			return true
+		}
+		if tsig := r.IsTsig(); tsig != nil && w.TsigStatus() == nil && strings.EqualFold(tsig.Hdr.Name, mdns.Fqdn(secondary.Key)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// transferRecords returns the records to send for an AXFR or IXFR request. IXFR requests are answered incrementally if
+// the history contains all changes since the serial of the secondary, otherwise the full zone is sent.
+func (z *servedZone) transferRecords(r *mdns.Msg) []mdns.RR {
+	if r.Question[0].Qtype == mdns.TypeIXFR && len(r.Ns) > 0 {
+		if clientSOA, ok := r.Ns[0].(*mdns.SOA); ok {
+			if clientSOA.Serial == z.SOA.Serial || isNewerSerial(clientSOA.Serial, z.SOA.Serial) {
+				return []mdns.RR{z.SOA}
+			}
+
+			for i, change := range z.History {
+				if change.From.Serial != clientSOA.Serial {
+					continue
+				}
+
+				records := []mdns.RR{z.SOA}
+				for _, change := range z.History[i:] {
+					records = append(records, change.From)
+					records = append(records, change.Removed...)
+					records = append(records, change.To)
+					records = append(records, change.Added...)
+				}
+				return append(records, z.SOA)
+			}
+		}
+	}
+
+	records := append([]mdns.RR{z.SOA}, z.Records...)
+	return append(records, z.SOA)
+}
+
+// signReply signs the reply with the key of the request if the request is signed with a valid key
+func signReply(w mdns.ResponseWriter, r *mdns.Msg, m *mdns.Msg) {
+	if tsig := r.IsTsig(); tsig != nil && w.TsigStatus() == nil {
+		m.SetTsig(tsig.Hdr.Name, tsig.Algorithm, tsig.Fudge, time.Now().Unix())
+	}
+}
+
+// transfer answers AXFR and IXFR requests of the secondaries of the zone over TCP
+func (s *Server) transfer(w mdns.ResponseWriter, r *mdns.Msg, zone *servedZone) {
+	if !zone.allowsTransfer(w, r) {
+		logger.Printf("Refused transfer of zone %s to %s", zone.Name, w.RemoteAddr())
+		m := new(mdns.Msg)
+		m.SetRcode(r, mdns.RcodeRefused)
+		signReply(w, r, m)
+		_ = w.WriteMsg(m)
+		return
+	}
+
+	if w.LocalAddr().Network() != "tcp" {
+		// IXFR over UDP, the secondary retries over TCP if the SOA is newer
+		m := new(mdns.Msg)
+		m.SetReply(r)
+		m.Authoritative = true
+		m.Answer = []mdns.RR{zone.SOA}
+		signReply(w, r, m)
+		_ = w.WriteMsg(m)
+		return
+	}
+
+	records := zone.transferRecords(r)
+	ch := make(chan *mdns.Envelope)
+	result := make(chan error)
+	go func() {
+		// every message of the transfer is signed with the key of a verified request
+		result <- new(mdns.Transfer).Out(w, r, ch)
+	}()
+
+	var err error
+send:
+	for start := 0; start < len(records); start += maxTransferChunk {
+		end := min(start+maxTransferChunk, len(records))
+		select {
+		case ch <- &mdns.Envelope{RR: records[start:end]}:
+		case err = <-result:
+			break send
+		}
+	}
+	close(ch)
+
+	if err == nil {
+		err = <-result
+	}
+	if err != nil {
+		logger.Printf("Transfer of zone %s to %s failed: %v", zone.Name, w.RemoteAddr(), err)
+	}
+}
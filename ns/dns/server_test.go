@@ -0,0 +1,392 @@
+package dns
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/go-test/deep"
+	mdns "github.com/miekg/dns"
+	"peg.nu/nx/config"
+)
+
+func testServerZone(serial string, records []resourceRecord) Zone {
+	return Zone{
+		Name: "example.com",
+		SOAInfo: SOAInfo{
+			NameserverFQDN:        "ns1.example.com.",
+			DottedMailResponsible: "admin.example.com",
+			TTL:                   600,
+			Refresh:               900,
+			Retry:                 900,
+			Expire:                172800,
+			BindDefaultRRTTL:      120,
+			Serial:                serial,
+		},
+		Records: records,
+	}
+}
+
+func testServerConf(secondaryAddresses ...string) *config.NXConfig {
+	var secondaries []config.AdditionalSecondary
+	for _, address := range secondaryAddresses {
+		secondaries = append(secondaries, config.ParseAdditionalSecondary(address))
+	}
+
+	return &config.NXConfig{Namespaces: config.NamespaceConfig{DNS: config.DNSNamespaceConfig{Primaries: []config.PrimaryConfig{
+		{
+			Name:                  "ns1.example.com",
+			IP:                    "192.0.2.1",
+			Zones:                 []string{"example.com"},
+			Output:                string(outputServe),
+			AdditionalSecondaries: config.AdditionalSecondariesConfig{"example.com": secondaries},
+		},
+	}}}}
+}
+
+var testServerRecords = []resourceRecord{
+	{Name: "web", Type: A, RData: "10.0.0.5"},
+	{Name: "www", Type: CName, RData: "web"},
+	{Name: "host.dept", Type: A, RData: "10.0.0.6"},
+	{Name: "lab", Type: NS, RData: "ns1.lab.example.com."},
+	{Name: "ns1.lab", Type: A, RData: "10.0.0.53"},
+}
+
+// startTestServer runs the server on random local UDP and TCP ports sharing the same port number
+func startTestServer(t *testing.T, server *Server) string {
+	packetConn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, err := net.Listen("tcp", packetConn.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, dnsServer := range []*mdns.Server{
+		{PacketConn: packetConn, Handler: server, TsigSecret: server.tsigSecrets},
+		{Listener: listener, Handler: server, TsigSecret: server.tsigSecrets},
+	} {
+		started := make(chan struct{})
+		dnsServer.NotifyStartedFunc = func() { close(started) }
+		go func() { _ = dnsServer.ActivateAndServe() }()
+		<-started
+		t.Cleanup(func() { _ = dnsServer.Shutdown() })
+	}
+
+	return packetConn.LocalAddr().String()
+}
+
+func query(t *testing.T, address, name string, qtype uint16) *mdns.Msg {
+	m := new(mdns.Msg)
+	m.SetQuestion(name, qtype)
+	response, _, err := new(mdns.Client).Exchange(m, address)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return response
+}
+
+func TestServerAnswers(t *testing.T) {
+	server := NewServer(testServerConf())
+	if err := server.Update([]Zone{testServerZone("24010100", testServerRecords)}); err != nil {
+		t.Fatal(err)
+	}
+	address := startTestServer(t, server)
+
+	tests := []struct {
+		name, qname    string
+		qtype          uint16
+		rcode          int
+		authoritative  bool
+		answer, ns, ex int
+	}{
+		{"address", "web.example.com.", mdns.TypeA, mdns.RcodeSuccess, true, 1, 0, 0},
+		{"case insensitive", "WEB.example.com.", mdns.TypeA, mdns.RcodeSuccess, true, 1, 0, 0},
+		{"cname chased", "www.example.com.", mdns.TypeA, mdns.RcodeSuccess, true, 2, 0, 0},
+		{"soa", "example.com.", mdns.TypeSOA, mdns.RcodeSuccess, true, 1, 0, 0},
+		{"apex ns", "example.com.", mdns.TypeNS, mdns.RcodeSuccess, true, 1, 0, 0},
+		{"nodata", "web.example.com.", mdns.TypeAAAA, mdns.RcodeSuccess, true, 0, 1, 0},
+		{"empty non-terminal", "dept.example.com.", mdns.TypeA, mdns.RcodeSuccess, true, 0, 1, 0},
+		{"nxdomain", "missing.example.com.", mdns.TypeA, mdns.RcodeNameError, true, 0, 1, 0},
+		{"referral", "host.lab.example.com.", mdns.TypeA, mdns.RcodeSuccess, false, 0, 1, 1},
+		{"not served", "example.net.", mdns.TypeA, mdns.RcodeRefused, false, 0, 0, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			response := query(t, address, test.qname, test.qtype)
+			if response.Rcode != test.rcode || response.Authoritative != test.authoritative {
+				t.Errorf("Expected rcode %s and aa %t; but was %s and %t", mdns.RcodeToString[test.rcode], test.authoritative, mdns.RcodeToString[response.Rcode], response.Authoritative)
+			}
+			if len(response.Answer) != test.answer || len(response.Ns) != test.ns || len(response.Extra) != test.ex {
+				t.Errorf("Expected %d/%d/%d records; but was %d/%d/%d: %v", test.answer, test.ns, test.ex, len(response.Answer), len(response.Ns), len(response.Extra), response)
+			}
+		})
+	}
+
+	nxdomain := query(t, address, "missing.example.com.", mdns.TypeA)
+	if soa, ok := nxdomain.Ns[0].(*mdns.SOA); !ok || soa.Hdr.Ttl != 120 || soa.Serial != 24010100 {
+		t.Errorf("Expected SOA with serial 24010100 and TTL 120 in authority section; but was <%v>", nxdomain.Ns[0])
+	}
+}
+
+func transferRRs(t *testing.T, address string, m *mdns.Msg, tsigSecret map[string]string) ([]mdns.RR, error) {
+	envelopes, err := (&mdns.Transfer{TsigSecret: tsigSecret}).In(m, address)
+	if err != nil {
+		return nil, err
+	}
+
+	var records []mdns.RR
+	for envelope := range envelopes {
+		if envelope.Error != nil {
+			return nil, envelope.Error
+		}
+		records = append(records, envelope.RR...)
+	}
+	return records, nil
+}
+
+func TestServerTransfers(t *testing.T) {
+	notifications := make(chan *mdns.Msg, 10)
+	notifyConn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	notifyServer := &mdns.Server{PacketConn: notifyConn, Handler: mdns.HandlerFunc(func(w mdns.ResponseWriter, r *mdns.Msg) {
+		notifications <- r
+		m := new(mdns.Msg)
+		m.SetReply(r)
+		_ = w.WriteMsg(m)
+	})}
+	go func() { _ = notifyServer.ActivateAndServe() }()
+	t.Cleanup(func() { _ = notifyServer.Shutdown() })
+
+	server := NewServer(testServerConf(notifyConn.LocalAddr().String()))
+	if err := server.Update([]Zone{testServerZone("24010100", testServerRecords)}); err != nil {
+		t.Fatal(err)
+	}
+	address := startTestServer(t, server)
+	expectNotify(t, notifications, 24010100)
+
+	axfr := new(mdns.Msg)
+	axfr.SetAxfr("example.com.")
+	records, err := transferRRs(t, address, axfr, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 8 {
+		t.Errorf("Expected AXFR with 2 SOA, 1 NS and 5 records; but got %d records: %v", len(records), records)
+	}
+
+	// unchanged zones keep their serial and are not notified again
+	if err := server.Update([]Zone{testServerZone("24010101", testServerRecords)}); err != nil {
+		t.Fatal(err)
+	}
+	if serial := query(t, address, "example.com.", mdns.TypeSOA).Answer[0].(*mdns.SOA).Serial; serial != 24010100 {
+		t.Errorf("Expected unchanged serial 24010100; but was %d", serial)
+	}
+
+	// an older generated serial is increased to stay newer than the served one
+	changed := append([]resourceRecord{{Name: "new", Type: A, RData: "10.0.0.7"}}, testServerRecords[1:]...)
+	if err := server.Update([]Zone{testServerZone("24010099", changed)}); err != nil {
+		t.Fatal(err)
+	}
+	expectNotify(t, notifications, 24010101)
+
+	ixfr := new(mdns.Msg)
+	ixfr.SetIxfr("example.com.", 24010100, "ns1.example.com.", "admin.example.com.")
+	records, err = transferRRs(t, address, ixfr, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"SOA 24010101", "SOA 24010100", "A web", "SOA 24010101", "A new", "SOA 24010101"}
+	if len(records) != len(expected) {
+		t.Fatalf("Expected IXFR %v; but got %v", expected, records)
+	}
+	for i, rr := range records {
+		var actual string
+		switch typed := rr.(type) {
+		case *mdns.SOA:
+			actual = fmt.Sprintf("SOA %d", typed.Serial)
+		case *mdns.A:
+			actual = "A " + mdns.SplitDomainName(typed.Hdr.Name)[0]
+		}
+		if actual != expected[i] {
+			t.Errorf("Expected record %d to be <%s>; but was <%s>", i, expected[i], actual)
+		}
+	}
+
+	ixfr.SetIxfr("example.com.", 24010101, "ns1.example.com.", "admin.example.com.")
+	records, err = transferRRs(t, address, ixfr, nil)
+	if err != nil || len(records) != 1 {
+		t.Errorf("Expected single SOA for an up to date secondary; but got %v, err %v", records, err)
+	}
+}
+
+func TestServerRefusesTransfer(t *testing.T) {
+	server := NewServer(testServerConf("192.0.2.53"))
+	if err := server.Update([]Zone{testServerZone("24010100", testServerRecords)}); err != nil {
+		t.Fatal(err)
+	}
+	address := startTestServer(t, server)
+
+	axfr := new(mdns.Msg)
+	axfr.SetAxfr("example.com.")
+	if _, err := transferRRs(t, address, axfr, nil); err == nil {
+		t.Errorf("Expected transfer to an unknown secondary to be refused")
+	}
+}
+
+func TestServerTransferTSIG(t *testing.T) {
+	conf := testServerConf()
+	conf.Namespaces.DNS.TSIGKeys = []config.TSIGKey{
+		{Name: "nx-transfer", Secret: "bngtdHJhbnNmZXI="},
+		{Name: "other", Secret: "b3RoZXI="},
+	}
+	conf.Namespaces.DNS.Primaries[0].AdditionalSecondaries["example.com"] = []config.AdditionalSecondary{{IP: "127.0.0.1", Key: "nx-transfer"}}
+	// the other key is only known to the server, it is not allowed to transfer the zone
+	conf.Namespaces.DNS.Primaries = append(conf.Namespaces.DNS.Primaries, config.PrimaryConfig{Name: "ns2.example.com", IP: "192.0.2.2", TransferKey: "other"})
+
+	server := NewServer(conf)
+	if err := server.Update([]Zone{testServerZone("24010100", testServerRecords)}); err != nil {
+		t.Fatal(err)
+	}
+	address := startTestServer(t, server)
+
+	axfr := new(mdns.Msg)
+	axfr.SetAxfr("example.com.")
+	if _, err := transferRRs(t, address, axfr, nil); err == nil {
+		t.Errorf("Expected unsigned transfer to be refused")
+	}
+
+	for key, expectAllowed := range map[string]bool{"nx-transfer.": true, "other.": false} {
+		axfr := new(mdns.Msg)
+		axfr.SetAxfr("example.com.")
+		axfr.SetTsig(key, mdns.HmacSHA256, 300, time.Now().Unix())
+		// the transfer fails if a reply is not signed with the key
+		records, err := transferRRs(t, address, axfr, server.tsigSecrets)
+		if expectAllowed && (err != nil || len(records) != 9) {
+			t.Errorf("Expected transfer signed with %s to be allowed; but got %v, err %v", key, records, err)
+		}
+		if !expectAllowed && err == nil {
+			t.Errorf("Expected transfer signed with %s to be refused", key)
+		}
+	}
+}
+
+func TestServerSignedNotify(t *testing.T) {
+	secrets := map[string]string{"nx-transfer.": "bngtdHJhbnNmZXI="}
+	notifications := make(chan error, 10)
+	notifyConn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	notifyServer := &mdns.Server{PacketConn: notifyConn, TsigSecret: secrets, Handler: mdns.HandlerFunc(func(w mdns.ResponseWriter, r *mdns.Msg) {
+		status := w.TsigStatus()
+		if r.IsTsig() == nil {
+			status = mdns.ErrNoSig
+		}
+		notifications <- status
+
+		m := new(mdns.Msg)
+		m.SetReply(r)
+		signReply(w, r, m)
+		_ = w.WriteMsg(m)
+	})}
+	go func() { _ = notifyServer.ActivateAndServe() }()
+	t.Cleanup(func() { _ = notifyServer.Shutdown() })
+
+	conf := testServerConf()
+	conf.Namespaces.DNS.TSIGKeys = []config.TSIGKey{{Name: "nx-transfer", Secret: secrets["nx-transfer."]}}
+	conf.Namespaces.DNS.Primaries[0].AdditionalSecondaries["example.com"] = []config.AdditionalSecondary{
+		{IP: "127.0.0.1", Port: notifyConn.LocalAddr().(*net.UDPAddr).Port, Key: "nx-transfer"},
+	}
+
+	server := NewServer(conf)
+	if err := server.Update([]Zone{testServerZone("24010100", testServerRecords)}); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case status := <-notifications:
+		if status != nil {
+			t.Errorf("Expected NOTIFY signed with the key of the secondary; but was <%v>", status)
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("Expected signed NOTIFY; but got none")
+	}
+}
+
+func TestApexNameservers(t *testing.T) {
+	soa := &mdns.SOA{Hdr: mdns.RR_Header{Name: "example.com."}, Ns: "ns1.example.com."}
+	secondaries := []servedSecondary{{Address: "192.0.2.2:53", Name: "NS2.example.com"}, {Address: "198.51.100.1:53"}}
+
+	var actual []string
+	for _, rr := range apexNameservers(soa, secondaries) {
+		actual = append(actual, rr.(*mdns.NS).Ns)
+	}
+	if diff := deep.Equal(actual, []string{"ns1.example.com.", "ns2.example.com."}); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func expectNotify(t *testing.T, notifications chan *mdns.Msg, serial uint32) {
+	select {
+	case notify := <-notifications:
+		soa, ok := notify.Answer[0].(*mdns.SOA)
+		if notify.Opcode != mdns.OpcodeNotify || !ok || soa.Serial != serial {
+			t.Errorf("Expected NOTIFY with serial %d; but got <%v>", serial, notify)
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("Expected NOTIFY with serial %d; but got none", serial)
+	}
+}
+
+func TestServerWildcards(t *testing.T) {
+	server := NewServer(testServerConf())
+	records := []resourceRecord{
+		{Name: "*.apps", Type: A, RData: "10.0.0.8"},
+		{Name: "api.apps", Type: A, RData: "10.0.0.9"},
+		{Name: "web", Type: A, RData: "10.0.0.5"},
+	}
+	if err := server.Update([]Zone{testServerZone("24010100", records)}); err != nil {
+		t.Fatal(err)
+	}
+	address := startTestServer(t, server)
+
+	tests := []struct {
+		qname string
+		qtype uint16
+		rcode int
+		rdata string
+	}{
+		{"foo.apps.example.com.", mdns.TypeA, mdns.RcodeSuccess, "10.0.0.8"},
+		{"a.b.apps.example.com.", mdns.TypeA, mdns.RcodeSuccess, "10.0.0.8"},
+		{"api.apps.example.com.", mdns.TypeA, mdns.RcodeSuccess, "10.0.0.9"},
+		{"foo.apps.example.com.", mdns.TypeAAAA, mdns.RcodeSuccess, ""},
+		// the closest encloser api.apps has no wildcard
+		{"sub.api.apps.example.com.", mdns.TypeA, mdns.RcodeNameError, ""},
+		{"missing.example.com.", mdns.TypeA, mdns.RcodeNameError, ""},
+	}
+
+	for _, test := range tests {
+		response := query(t, address, test.qname, test.qtype)
+		if response.Rcode != test.rcode {
+			t.Errorf("Expected rcode %s for %s; but was %s", mdns.RcodeToString[test.rcode], test.qname, mdns.RcodeToString[response.Rcode])
+		}
+
+		if len(test.rdata) == 0 {
+			if len(response.Answer) != 0 {
+				t.Errorf("Expected no answer for %s; but was <%v>", test.qname, response.Answer)
+			}
+			continue
+		}
+		a, ok := response.Answer[0].(*mdns.A)
+		if len(response.Answer) != 1 || !ok || a.A.String() != test.rdata || a.Hdr.Name != test.qname {
+			t.Errorf("Expected A %s owned by %s; but was <%v>", test.rdata, test.qname, response.Answer)
+		}
+	}
+}
@@ -0,0 +1,254 @@
+package dns
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+
+	"peg.nu/nx/cache"
+	"peg.nu/nx/config"
+	"peg.nu/nx/util"
+)
+
+const defaultTSIGKeyStore = "tsig-keys.json"
+const defaultTSIGAlgorithm = "hmac-sha256"
+
+// tsigSecretSize is the size of generated secrets in bytes, matching the output size of hmac-sha256
+const tsigSecretSize = 32
+
+func tsigKeyStorePath(conf *config.NXConfig) string {
+	if len(conf.Namespaces.DNS.TSIGKeyStore) == 0 {
+		return defaultTSIGKeyStore
+	}
+
+	return conf.Namespaces.DNS.TSIGKeyStore
+}
+
+func readTSIGKeyStore(path string) []config.TSIGKey {
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		panic(err)
+	}
+
+	var keys []config.TSIGKey
+	err = json.Unmarshal(content, &keys)
+	if err != nil {
+		panic(fmt.Errorf("could not read TSIG key store %s: %w", path, err))
+	}
+
+	return keys
+}
+
+func writeTSIGKeyStore(path string, keys []config.TSIGKey) {
+	content, err := json.MarshalIndent(keys, "", "  ")
+	if err != nil {
+		panic(err)
+	}
+
+	err = os.WriteFile(path, content, 0600)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func generateTSIGKey(name string) config.TSIGKey {
+	secret := make([]byte, tsigSecretSize)
+	_, err := rand.Read(secret)
+	if err != nil {
+		panic(err)
+	}
+
+	return config.TSIGKey{Name: name, Algorithm: defaultTSIGAlgorithm, Secret: base64.StdEncoding.EncodeToString(secret)}
+}
+
+func findTSIGKey(keys []config.TSIGKey, name string) (config.TSIGKey, bool) {
+	for _, key := range keys {
+		if key.Name == name && len(key.Secret) > 0 {
+			return key, true
+		}
+	}
+
+	return config.TSIGKey{}, false
+}
+
+// transferKeyNames returns the names of the transfer keys of all primaries and their additional secondaries and the
+// keys of their views
+func transferKeyNames(conf *config.NXConfig) []string {
+	var names []string
+	addName := func(name string) {
+		if len(name) > 0 && !util.SliceContainsString(names, name) {
+			names = append(names, name)
+		}
+	}
+
+	for _, primary := range conf.Namespaces.DNS.Primaries {
+		addName(primary.TransferKey)
+		for _, view := range primaryViewNames(&primary) {
+			addName(viewKeyName(view))
+		}
+		for _, zone := range additionalSecondaryZones(&primary) {
+			for _, secondary := range primary.AdditionalSecondaries[zone] {
+				addName(secondary.Key)
+			}
+		}
+	}
+
+	return names
+}
+
+// resolveTransferKeys returns the transfer and view keys of all primaries and additional secondaries by key name. Keys not
+// declared in the config are taken from the key store or generated and added to the key store.
+func resolveTransferKeys(conf *config.NXConfig) map[string]config.TSIGKey {
+	keys := make(map[string]config.TSIGKey)
+	storePath := tsigKeyStorePath(conf)
+	var store []config.TSIGKey
+	storeLoaded, storeChanged := false, false
+
+	for _, name := range transferKeyNames(conf) {
+
+		if key, ok := findTSIGKey(conf.Namespaces.DNS.TSIGKeys, name); ok {
+			keys[name] = key
+			continue
+		}
+
+		if !storeLoaded {
+			store = readTSIGKeyStore(storePath)
+			storeLoaded = true
+		}
+		key, ok := findTSIGKey(store, name)
+		if !ok {
+			logger.Printf("Generating TSIG key %s and storing it in %s", name, storePath)
+			key = generateTSIGKey(name)
+			store = append(store, key)
+			storeChanged = true
+		}
+		keys[name] = key
+	}
+
+	if storeChanged {
+		writeTSIGKeyStore(storePath, store)
+	}
+
+	for name, key := range keys {
+		if len(key.Algorithm) == 0 {
+			key.Algorithm = defaultTSIGAlgorithm
+		}
+		key.Algorithm = strings.ToLower(key.Algorithm)
+		keys[name] = key
+	}
+
+	return keys
+}
+
+// keyedServer is a server all messages to are signed with the key
+type keyedServer struct {
+	IP  string
+	Key string
+}
+
+type keysTemplateVars struct {
+	ServerName  string
+	GeneratedAt string
+	Keys        []config.TSIGKey
+}
+
+// keysFileName returns the name of the include file containing the key secrets of the nameserver
+func keysFileName(serverName string) string {
+	return fmt.Sprintf("%s.keys.conf", serverName)
+}
+
+// primaryKeys returns the keys the nameserver needs: its own transfer key, the transfer keys of all other primaries
+// it is a secondary of and the keys of its additional secondaries
+func primaryKeys(currentPrimary config.PrimaryConfig, keys map[string]config.TSIGKey, conf *config.NXConfig) ([]config.TSIGKey, []keyedServer) {
+	var primaryKeys []config.TSIGKey
+	var servers []keyedServer
+	addKey := func(name string) {
+		for _, key := range primaryKeys {
+			if key.Name == name {
+				return
+			}
+		}
+		primaryKeys = append(primaryKeys, keys[name])
+	}
+	addServer := func(server keyedServer) {
+		for _, existing := range servers {
+			if existing.IP == server.IP {
+				return
+			}
+		}
+		servers = append(servers, server)
+	}
+
+	if len(currentPrimary.TransferKey) > 0 {
+		addKey(currentPrimary.TransferKey)
+	}
+	for _, primary := range conf.Namespaces.DNS.Primaries {
+		if primary.Name == currentPrimary.Name || len(primary.TransferKey) == 0 {
+			continue
+		}
+
+		addKey(primary.TransferKey)
+		addServer(keyedServer{IP: primary.IP, Key: primary.TransferKey})
+	}
+	for _, zone := range additionalSecondaryZones(&currentPrimary) {
+		for _, secondary := range currentPrimary.AdditionalSecondaries[zone] {
+			if len(secondary.Key) == 0 {
+				continue
+			}
+
+			addKey(secondary.Key)
+			addServer(keyedServer{IP: secondary.IP, Key: secondary.Key})
+		}
+	}
+
+	return primaryKeys, servers
+}
+
+func newKeysWriter(software serverSoftware) *cache.CachedTemplateWriter {
+	templateString, err := os.ReadFile(fmt.Sprintf("templates/%s-keys.tmpl", software))
+	if err != nil {
+		panic(err)
+	}
+	keysTemplate := template.Must(template.New("keys").Parse(string(templateString)))
+	ignoreRegexes := []*regexp.Regexp{
+		regexp.MustCompile("(?m)^ \\* Generated at.*$"),
+		regexp.MustCompile("(?m)^# Generated at.*$"),
+	}
+
+	return cache.New(keysTemplate, ignoreRegexes, false)
+}
+
+// writeKeysFile writes the key statements of the nameserver to a separate file in the directory, which is only readable
+// by the owner
+func writeKeysFile(cw *cache.CachedTemplateWriter, directory string, serverName string, keys []config.TSIGKey) string {
+	file := fmt.Sprintf("%s/%s", directory, keysFileName(serverName))
+
+	// create the file with restricted permissions before writing the secrets, existing files keep their permissions
+	f, err := os.OpenFile(file, os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		panic(err)
+	}
+	_ = f.Close()
+
+	_, err = cw.WriteTemplate(file, keysTemplateVars{ServerName: serverName, GeneratedAt: time.Now().Format(time.RFC3339), Keys: keys})
+	if err != nil {
+		panic(err)
+	}
+
+	err = os.Chmod(file, 0600)
+	if err != nil {
+		panic(err)
+	}
+
+	return file
+}
@@ -0,0 +1,82 @@
+package dns
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/go-test/deep"
+	"peg.nu/nx/config"
+)
+
+func TestResolveTransferKeys(t *testing.T) {
+	storePath := filepath.Join(t.TempDir(), "tsig-keys.json")
+	writeTSIGKeyStore(storePath, []config.TSIGKey{{Name: "stored", Secret: "c3RvcmVk"}})
+
+	conf := &config.NXConfig{Namespaces: config.NamespaceConfig{DNS: config.DNSNamespaceConfig{
+		TSIGKeyStore: storePath,
+		TSIGKeys:     []config.TSIGKey{{Name: "configured", Algorithm: "HMAC-SHA512", Secret: "Y29uZmlndXJlZA=="}},
+		Primaries: []config.PrimaryConfig{
+			{Name: "ns1.example.com", TransferKey: "configured"},
+			{Name: "ns2.example.com", TransferKey: "stored"},
+			{Name: "ns3.example.com", TransferKey: "generated"},
+			{Name: "ns4.example.com"},
+		},
+	}}}
+
+	keys := resolveTransferKeys(conf)
+	if len(keys) != 3 {
+		t.Errorf("Expected 3 keys; but was <%v>", keys)
+	}
+	if diff := deep.Equal(keys["configured"], config.TSIGKey{Name: "configured", Algorithm: "hmac-sha512", Secret: "Y29uZmlndXJlZA=="}); diff != nil {
+		t.Error(diff)
+	}
+	if diff := deep.Equal(keys["stored"], config.TSIGKey{Name: "stored", Algorithm: defaultTSIGAlgorithm, Secret: "c3RvcmVk"}); diff != nil {
+		t.Error(diff)
+	}
+
+	generated := keys["generated"]
+	if generated.Algorithm != defaultTSIGAlgorithm || len(generated.Secret) == 0 {
+		t.Errorf("Expected a generated %s key; but was <%v>", defaultTSIGAlgorithm, generated)
+	}
+
+	// the generated key is persisted and reused in the next run
+	stored, ok := findTSIGKey(readTSIGKeyStore(storePath), "generated")
+	if !ok || stored.Secret != generated.Secret {
+		t.Errorf("Expected generated key <%v> in key store; but was <%v>", generated, stored)
+	}
+	if actual := resolveTransferKeys(conf)["generated"]; actual.Secret != generated.Secret {
+		t.Errorf("Expected generated secret <%s> to be reused; but was <%s>", generated.Secret, actual.Secret)
+	}
+}
+
+func TestPrimaryKeys(t *testing.T) {
+	conf := &config.NXConfig{Namespaces: config.NamespaceConfig{DNS: config.DNSNamespaceConfig{Primaries: []config.PrimaryConfig{
+		{Name: "ns1.example.com", IP: "192.0.2.1", TransferKey: "ns1", AdditionalSecondaries: config.AdditionalSecondariesConfig{
+			"example.com": {{IP: "198.51.100.7", Key: "provider"}, {IP: "198.51.100.8"}},
+			"example.net": {{IP: "198.51.100.7", Key: "provider"}},
+		}},
+		{Name: "ns2.example.com", IP: "192.0.2.2", TransferKey: "ns2"},
+		{Name: "ns3.example.com", IP: "192.0.2.3"},
+	}}}}
+	keys := map[string]config.TSIGKey{
+		"ns1":      {Name: "ns1", Algorithm: defaultTSIGAlgorithm, Secret: "bnMx"},
+		"ns2":      {Name: "ns2", Algorithm: defaultTSIGAlgorithm, Secret: "bnMy"},
+		"provider": {Name: "provider", Algorithm: defaultTSIGAlgorithm, Secret: "cHJvdmlkZXI="},
+	}
+
+	keyList, servers := primaryKeys(conf.Namespaces.DNS.Primaries[0], keys, conf)
+	if diff := deep.Equal(keyList, []config.TSIGKey{keys["ns1"], keys["ns2"], keys["provider"]}); diff != nil {
+		t.Error(diff)
+	}
+	if diff := deep.Equal(servers, []keyedServer{{IP: "192.0.2.2", Key: "ns2"}, {IP: "198.51.100.7", Key: "provider"}}); diff != nil {
+		t.Error(diff)
+	}
+
+	keyList, servers = primaryKeys(conf.Namespaces.DNS.Primaries[2], keys, conf)
+	if diff := deep.Equal(keyList, []config.TSIGKey{keys["ns1"], keys["ns2"]}); diff != nil {
+		t.Error(diff)
+	}
+	if len(servers) != 2 {
+		t.Errorf("Expected servers of ns1 and ns2; but was <%v>", servers)
+	}
+}
@@ -0,0 +1,254 @@
+package dns
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	mdns "github.com/miekg/dns"
+	"peg.nu/nx/util"
+)
+
+type validationSeverity string
+
+const (
+	severityError   validationSeverity = "error"
+	severityWarning validationSeverity = "warning"
+)
+
+type validationIssue struct {
+	Zone     string
+	Severity validationSeverity
+	Message  string
+}
+
+func (i validationIssue) String() string {
+	return fmt.Sprintf("%s: %s: %s", i.Zone, i.Severity, i.Message)
+}
+
+var hostnameLabelRegex = regexp.MustCompile("^(?i)[a-z0-9]([a-z0-9-]*[a-z0-9])?$")
+var includeRegex = regexp.MustCompile("(?m)^\\s*\\$INCLUDE.*$")
+
+// ValidateZoneFiles validates all zone files in the directory, similar to named-checkzone. Warnings are logged, an error
+// is returned if any zone file is invalid.
+func ValidateZoneFiles(directory string) error {
+	dirEntries, err := os.ReadDir(directory)
+	if err != nil {
+		return err
+	}
+
+	contents := make(map[string]string)
+	for _, dirEntry := range dirEntries {
+		if dirEntry.IsDir() || !strings.HasSuffix(dirEntry.Name(), ".db") {
+			continue
+		}
+
+		content, err := os.ReadFile(filepath.Join(directory, dirEntry.Name()))
+		if err != nil {
+			return err
+		}
+		contents[dirEntry.Name()] = string(content)
+	}
+
+	return validateZoneContents(contents, directory)
+}
+
+// validateZoneContents validates the zone files given by their file name, which can still be rendered in memory. The
+// location is only used in the messages.
+func validateZoneContents(contents map[string]string, location string) error {
+	var files []string
+	var zones []string
+	for file := range contents {
+		files = append(files, file)
+		zone, _, _ := strings.Cut(strings.TrimSuffix(file, ".db"), "@")
+		if !util.SliceContainsString(zones, zone) {
+			zones = append(zones, zone)
+		}
+	}
+	sort.Strings(files)
+	sort.Strings(zones)
+
+	errorCount := 0
+	for _, file := range files {
+		zone, _, _ := strings.Cut(strings.TrimSuffix(file, ".db"), "@")
+		for _, issue := range validateZone(zone, contents[file], zones) {
+			logger.Println(issue.String())
+			if issue.Severity == severityError {
+				errorCount++
+			}
+		}
+	}
+
+	if errorCount > 0 {
+		return fmt.Errorf("zone validation found %d error(s) in %s", errorCount, location)
+	}
+	logger.Printf("Validated %d zone file(s) in %s\n", len(files), location)
+	return nil
+}
+
+// validateZone validates the content of a single zone file. Records of the other zones are treated as out of this zone.
+// $INCLUDE directives are not followed, as the included files only exist on the nameservers.
+func validateZone(zone string, content string, zones []string) []validationIssue {
+	var issues []validationIssue
+	addIssue := func(severity validationSeverity, format string, args ...interface{}) {
+		issues = append(issues, validationIssue{Zone: zone, Severity: severity, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if includeRegex.MatchString(content) {
+		addIssue(severityWarning, "$INCLUDE directives are not validated")
+		content = includeRegex.ReplaceAllString(content, ";")
+	}
+
+	origin := mdns.Fqdn(strings.ToLower(zone))
+	parser := mdns.NewZoneParser(strings.NewReader(content), origin, zone)
+	parser.SetDefaultTTL(3600)
+
+	var records []mdns.RR
+	for rr, ok := parser.Next(); ok; rr, ok = parser.Next() {
+		records = append(records, rr)
+	}
+	if err := parser.Err(); err != nil {
+		addIssue(severityError, "syntax error: %v", err)
+		return issues
+	}
+
+	// names below other, more specific zones do not belong to this zone
+	belongsToZone := func(name string) bool {
+		if !mdns.IsSubDomain(origin, name) {
+			return false
+		}
+		for _, other := range zones {
+			otherOrigin := mdns.Fqdn(strings.ToLower(other))
+			if otherOrigin != origin && mdns.IsSubDomain(origin, otherOrigin) && mdns.IsSubDomain(otherOrigin, name) {
+				return false
+			}
+		}
+		return true
+	}
+
+	owners := make(map[string][]mdns.RR)
+	soaCount := 0
+	for i, rr := range records {
+		header := rr.Header()
+		name := strings.ToLower(header.Name)
+		rrType := mdns.TypeToString[header.Rrtype]
+
+		if !belongsToZone(name) {
+			addIssue(severityError, "%s %s is out of zone", name, rrType)
+			continue
+		}
+		validateName(name, header.Rrtype, addIssue)
+
+		for _, previous := range records[:i] {
+			if mdns.IsDuplicate(previous, rr) {
+				addIssue(severityError, "duplicate record %s", rr.String())
+				break
+			}
+		}
+
+		if header.Rrtype == mdns.TypeSOA {
+			soaCount++
+			if name != origin {
+				addIssue(severityError, "SOA record %s is not at the zone apex", name)
+			}
+		}
+
+		owners[name] = append(owners[name], rr)
+	}
+
+	if soaCount == 0 {
+		addIssue(severityError, "zone has no SOA record")
+	} else if soaCount > 1 {
+		addIssue(severityError, "zone has %d SOA records", soaCount)
+	}
+
+	apexHasNS := false
+	for _, rr := range owners[origin] {
+		if rr.Header().Rrtype == mdns.TypeNS {
+			apexHasNS = true
+		}
+	}
+	if !apexHasNS {
+		addIssue(severityError, "zone has no NS record at the apex")
+	}
+
+	ownerNames := make([]string, 0, len(owners))
+	for name := range owners {
+		ownerNames = append(ownerNames, name)
+	}
+	sort.Strings(ownerNames)
+
+	for _, name := range ownerNames {
+		var cnames []*mdns.CNAME
+		for _, rr := range owners[name] {
+			if cname, ok := rr.(*mdns.CNAME); ok {
+				cnames = append(cnames, cname)
+			}
+		}
+		if len(cnames) == 0 {
+			continue
+		}
+
+		if len(owners[name]) > len(cnames) {
+			addIssue(severityError, "%s has a CNAME and other data", name)
+		}
+		if len(cnames) > 1 {
+			addIssue(severityError, "%s has %d CNAME records", name, len(cnames))
+		}
+
+		for _, cname := range cnames {
+			target := strings.ToLower(cname.Target)
+			if !belongsToZone(target) {
+				addIssue(severityWarning, "CNAME %s points to out of zone target %s", name, target)
+				continue
+			}
+			if _, exists := owners[target]; !exists && !isOccluded(target, origin, owners) {
+				addIssue(severityError, "CNAME %s points to non-existent in-zone target %s", name, target)
+			}
+		}
+	}
+
+	return issues
+}
+
+// validateName checks label and name lengths and, for address records, the hostname syntax of RFC 1123
+func validateName(name string, rrType uint16, addIssue func(severity validationSeverity, format string, args ...interface{})) {
+	if len(name) > 254 {
+		addIssue(severityError, "name %s is longer than 253 characters", name)
+	}
+
+	for i, label := range mdns.SplitDomainName(name) {
+		if len(label) > 63 {
+			addIssue(severityError, "label %s of %s is longer than 63 characters", label, name)
+		}
+
+		if rrType != mdns.TypeA && rrType != mdns.TypeAAAA {
+			continue
+		}
+		if i == 0 && label == "*" {
+			continue
+		}
+		if !hostnameLabelRegex.MatchString(label) {
+			addIssue(severityError, "label %s of host %s contains invalid characters", label, name)
+		}
+	}
+}
+
+// isOccluded returns true if name is below a delegation point within the zone
+func isOccluded(name, origin string, owners map[string][]mdns.RR) bool {
+	for owner, rrs := range owners {
+		if owner == origin || owner == name || !mdns.IsSubDomain(owner, name) {
+			continue
+		}
+		for _, rr := range rrs {
+			if rr.Header().Rrtype == mdns.TypeNS {
+				return true
+			}
+		}
+	}
+
+	return false
+}
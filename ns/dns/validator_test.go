@@ -0,0 +1,97 @@
+package dns
+
+import (
+	"strings"
+	"testing"
+)
+
+const validatorTestZone = `$TTL	120
+@	IN SOA	ns1.example.com. user.example.com. (
+	21010100	; serial
+	900	; slave refresh interval
+	900	; slave retry interval
+	172800	; slave copy expire interval
+	600	; NXDOMAIN cache time
+)
+
+; Nameserver
+@ NS ns1.example.com.
+
+; Includes
+    $INCLUDE /etc/bind/zones/include.example.com.db
+
+; Name	Type	RData
+web	A	10.0.0.5
+www	CNAME	web
+ext	CNAME	www.example.net.
+lab	NS	ns1.lab.example.com.
+ns1.lab	A	10.0.0.53
+svc	CNAME	host.lab
+`
+
+func TestValidZone(t *testing.T) {
+	issues := validateZone("example.com", validatorTestZone, []string{"example.com"})
+
+	for _, issue := range issues {
+		if issue.Severity == severityError {
+			t.Errorf("Unexpected error: %s", issue)
+		}
+	}
+	if len(issues) != 2 {
+		t.Errorf("Expected warnings for the include and the out of zone CNAME; but got %v", issues)
+	}
+}
+
+func TestInvalidZones(t *testing.T) {
+	expectations := map[string]string{
+		"web	A	10.0.0.5\nweb	CNAME	www\n":         "has a CNAME and other data",
+		"www	CNAME	missing\n":                     "non-existent in-zone target",
+		"web_01	A	10.0.0.5\n":                     "invalid characters",
+		"web	A	10.0.0.5\nweb	A	10.0.0.5\n":        "duplicate record",
+		"web.example.net.	A	10.0.0.5\n":           "out of zone",
+		strings.Repeat("a", 64) + "	A	10.0.0.5\n": "syntax error",
+		"web	A	10.0.0.300\n":                      "syntax error",
+	}
+
+	for records, expected := range expectations {
+		issues := validateZone("example.com", validatorTestZone+records, []string{"example.com"})
+		found := false
+		for _, issue := range issues {
+			if issue.Severity == severityError && strings.Contains(issue.Message, expected) {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("Expected error <%s> for records <%s>; but got %v", expected, records, issues)
+		}
+	}
+
+	issues := validateZone("example.com", "web	A	10.0.0.5\n", []string{"example.com"})
+	if len(issues) != 2 {
+		t.Errorf("Expected missing SOA and NS errors; but got %v", issues)
+	}
+}
+
+func TestClasslessCNameTarget(t *testing.T) {
+	zone := strings.ReplaceAll(validatorTestZone, "example.com.", "2.0.192.in-addr.arpa.")
+	zone = zone[:strings.Index(zone, "; Name\t")] + "5	CNAME	5.0-31\n"
+
+	issues := validateZone("2.0.192.in-addr.arpa", zone, []string{"2.0.192.in-addr.arpa", "0-31.2.0.192.in-addr.arpa"})
+	for _, issue := range issues {
+		if issue.Severity == severityError {
+			t.Errorf("Unexpected error: %s", issue)
+		}
+	}
+}
+
+func TestValidateZoneContents(t *testing.T) {
+	contents := map[string]string{"example.com.db": validatorTestZone, "example.com@external.db": validatorTestZone}
+	if err := validateZoneContents(contents, "test"); err != nil {
+		t.Errorf("Expected valid zones; but got %v", err)
+	}
+
+	contents["example.org.db"] = "web	A	10.0.0.5\n"
+	if err := validateZoneContents(contents, "test"); err == nil {
+		t.Errorf("Expected the zone without SOA to fail the validation")
+	}
+}
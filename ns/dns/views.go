@@ -0,0 +1,108 @@
+package dns
+
+import (
+	"fmt"
+	"sort"
+
+	"peg.nu/nx/config"
+	"peg.nu/nx/util"
+)
+
+// zoneFileName returns the name of the file of the zone in the view
+func zoneFileName(zone, view string) string {
+	if len(view) == 0 {
+		return fmt.Sprintf("%s.db", zone)
+	}
+
+	return fmt.Sprintf("%s@%s.db", zone, view)
+}
+
+func primaryViewNames(primary *config.PrimaryConfig) []string {
+	if primary == nil {
+		return nil
+	}
+
+	names := make([]string, 0, len(primary.Views))
+	for _, view := range primary.Views {
+		names = append(names, view.Name)
+	}
+	return names
+}
+
+// viewKeyName returns the name of the TSIG key the primaries sign transfers and notifies of the view with, so they
+// are matched to the same view on the other primaries
+func viewKeyName(view string) string {
+	return fmt.Sprintf("nx-view-%s", view)
+}
+
+// viewMatchClients returns the match-clients of the view of the primary. Messages signed with the key of the view
+// always match it and messages signed with the key of another view never do, before the configured clients are matched.
+func viewMatchClients(view string, primary *config.PrimaryConfig) []string {
+	matchClients := []string{fmt.Sprintf("key \"%s\"", viewKeyName(view))}
+	for _, other := range primary.Views {
+		if other.Name != view {
+			matchClients = append(matchClients, fmt.Sprintf("!key \"%s\"", viewKeyName(other.Name)))
+		}
+	}
+	for _, other := range primary.Views {
+		if other.Name == view {
+			matchClients = append(matchClients, other.MatchClients...)
+		}
+	}
+
+	return matchClients
+}
+
+// viewServers returns the other primaries with the view, which transfer its zones signed with the view key
+func viewServers(view string, currentPrimary *config.PrimaryConfig, conf *config.NXConfig) []keyedServer {
+	var servers []keyedServer
+	for _, primary := range conf.Namespaces.DNS.Primaries {
+		if primary.Name == currentPrimary.Name || !util.SliceContainsString(primaryViewNames(&primary), view) {
+			continue
+		}
+
+		servers = append(servers, keyedServer{IP: primary.IP, Key: viewKeyName(view)})
+	}
+
+	return servers
+}
+
+// splitViews materializes the zones for every view of their primary. Records without a view are visible in all views.
+// If the primary of a zone has no views, all records are put into a single zone.
+func splitViews(zoneRecordsMap map[string][]resourceRecord, conf *config.NXConfig) []Zone {
+	zones := make([]string, 0, len(zoneRecordsMap))
+	for zone := range zoneRecordsMap {
+		zones = append(zones, zone)
+	}
+	sort.Strings(zones)
+
+	var zoneViews []Zone
+	for _, zone := range zones {
+		records := zoneRecordsMap[zone]
+		views := primaryViewNames(util.FindPrimaryForZone(*conf, zone))
+		if len(views) == 0 {
+			zoneViews = append(zoneViews, Zone{Name: zone, Records: records})
+			continue
+		}
+
+		unknownViews := make(map[string]bool)
+		for _, record := range records {
+			if len(record.View) > 0 && !util.SliceContainsString(views, record.View) && !unknownViews[record.View] {
+				unknownViews[record.View] = true
+				logger.Printf("View %s of records in zone %s is not configured for its primary, ignoring them", record.View, zone)
+			}
+		}
+
+		for _, view := range views {
+			var viewRecords []resourceRecord
+			for _, record := range records {
+				if len(record.View) == 0 || record.View == view {
+					viewRecords = append(viewRecords, record)
+				}
+			}
+			zoneViews = append(zoneViews, Zone{Name: zone, View: view, Records: viewRecords})
+		}
+	}
+
+	return zoneViews
+}
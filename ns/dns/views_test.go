@@ -0,0 +1,118 @@
+package dns
+
+import (
+	"bytes"
+	"os"
+	"peg.nu/nx/config"
+	"strings"
+	"testing"
+	"text/template"
+
+	"github.com/go-test/deep"
+)
+
+func TestSplitViews(t *testing.T) {
+	conf := &config.NXConfig{Namespaces: config.NamespaceConfig{DNS: config.DNSNamespaceConfig{Primaries: []config.PrimaryConfig{
+		{Name: "ns1", Zones: []string{"example.com"}, Views: []config.DNSView{{Name: "internal"}, {Name: "external"}}},
+		{Name: "ns2", Zones: []string{"example.net"}},
+	}}}}
+
+	zoneRecordsMap := map[string][]resourceRecord{
+		"example.com": {
+			{Name: "web", Type: A, RData: "10.0.0.5", View: "internal"},
+			{Name: "web", Type: A, RData: "203.0.113.5", View: "external"},
+			{Name: "mail", Type: A, RData: "203.0.113.25"},
+			{Name: "dev", Type: A, RData: "10.0.0.6", View: "unknown"},
+		},
+		"example.net": {
+			{Name: "web", Type: A, RData: "10.0.0.5", View: "internal"},
+		},
+	}
+
+	expected := []Zone{
+		{Name: "example.com", View: "internal", Records: []resourceRecord{
+			{Name: "web", Type: A, RData: "10.0.0.5", View: "internal"},
+			{Name: "mail", Type: A, RData: "203.0.113.25"},
+		}},
+		{Name: "example.com", View: "external", Records: []resourceRecord{
+			{Name: "web", Type: A, RData: "203.0.113.5", View: "external"},
+			{Name: "mail", Type: A, RData: "203.0.113.25"},
+		}},
+		{Name: "example.net", Records: []resourceRecord{
+			{Name: "web", Type: A, RData: "10.0.0.5", View: "internal"},
+		}},
+	}
+
+	if diff := deep.Equal(expected, splitViews(zoneRecordsMap, conf)); diff != nil {
+		t.Error(diff)
+	}
+	if name := zoneFileName("example.com", "internal"); name != "example.com@internal.db" {
+		t.Errorf("Expected file name <example.com@internal.db>; but was <%s>", name)
+	}
+}
+
+func TestViewTransferKeys(t *testing.T) {
+	conf := &config.NXConfig{Namespaces: config.NamespaceConfig{DNS: config.DNSNamespaceConfig{Primaries: []config.PrimaryConfig{
+		{Name: "ns1", IP: "192.0.2.1", Views: []config.DNSView{{Name: "internal", MatchClients: []string{"10.0.0.0/8"}}, {Name: "external", MatchClients: []string{"any"}}}},
+		{Name: "ns2", IP: "192.0.2.2", Views: []config.DNSView{{Name: "internal"}}},
+		{Name: "ns3", IP: "192.0.2.3"},
+	}}}}
+	primary := &conf.Namespaces.DNS.Primaries[0]
+
+	expectedClients := []string{`key "nx-view-internal"`, `!key "nx-view-external"`, "10.0.0.0/8"}
+	if diff := deep.Equal(viewMatchClients("internal", primary), expectedClients); diff != nil {
+		t.Error(diff)
+	}
+
+	expectedServers := []keyedServer{{IP: "192.0.2.2", Key: "nx-view-internal"}}
+	if diff := deep.Equal(viewServers("internal", primary, conf), expectedServers); diff != nil {
+		t.Error(diff)
+	}
+	if servers := viewServers("external", primary, conf); len(servers) != 0 {
+		t.Errorf("Expected no servers for view external; but was <%v>", servers)
+	}
+
+	zones := []templateZone{
+		{Name: "example.com", TransferKey: "ns1-transfer", primaryViews: []string{"internal", "external"}},
+		{Name: "example.net", primaryViews: []string{"internal"}},
+		{Name: "example.org"},
+	}
+	expectedKeys := map[string]string{"example.com": "nx-view-external", "example.net": "", "example.org": ""}
+	for _, zone := range withFileNames(zones, "external") {
+		if zone.ViewKey != expectedKeys[zone.Name] {
+			t.Errorf("Expected view key <%s> for %s; but was <%s>", expectedKeys[zone.Name], zone.Name, zone.ViewKey)
+		}
+	}
+}
+
+func TestViewDnssecConfig(t *testing.T) {
+	templateString, err := os.ReadFile("../../templates/bind-config.tmpl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	configTemplate := template.Must(template.New("config").Parse(string(templateString)))
+
+	zones := []templateZone{
+		{Name: "example.com", Type: zonePrimary, IsDnssecEnabled: true, DnssecPolicy: "default"},
+		{Name: "example.net", Type: zoneSecondary, IsSecondary: true, IsDnssecEnabled: true, DnssecPolicy: "default"},
+	}
+	vars := configTemplateVars{ServerName: "ns1"}
+	for _, view := range []string{"internal", "external"} {
+		vars.Views = append(vars.Views, templateView{Name: view, Zones: withFileNames(zones, view)})
+	}
+
+	var rendered bytes.Buffer
+	if err := configTemplate.Execute(&rendered, vars); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, view := range []string{"internal", "external"} {
+		directory := `key-directory "/var/cache/bind/keys/` + view + `";`
+		if count := strings.Count(rendered.String(), directory); count != 1 {
+			t.Errorf("Expected <%s> once in the config; but was %d times in %s", directory, count, rendered.String())
+		}
+	}
+	if count := strings.Count(rendered.String(), "key-directory"); count != 2 {
+		t.Errorf("Expected 2 key directories; but was %d in %s", count, rendered.String())
+	}
+}
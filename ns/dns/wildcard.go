@@ -0,0 +1,26 @@
+package dns
+
+import (
+	"fmt"
+	"strings"
+)
+
+// wildcardName returns the wildcard owner name covering all names below the given relative name, the whole zone if it
+// is empty or the apex
+func wildcardName(name string) string {
+	name = normalizeZoneName(strings.TrimPrefix(strings.TrimSpace(name), "*."))
+	if len(name) == 0 || name == apexName || name == "*" {
+		return "*"
+	}
+
+	return fmt.Sprintf("*.%s", name)
+}
+
+// wildcardParent returns the name the wildcard owner name covers the children of, or false if it is not a wildcard
+func wildcardParent(name string) (string, bool) {
+	if name == "*" {
+		return apexName, true
+	}
+
+	return strings.CutPrefix(name, "*.")
+}
@@ -0,0 +1,54 @@
+package dns
+
+import (
+	"testing"
+
+	"github.com/go-test/deep"
+	"peg.nu/nx/config"
+	"peg.nu/nx/model"
+)
+
+func TestWildcardName(t *testing.T) {
+	tests := map[string]string{"": "*", "@": "*", "*": "*", "apps": "*.apps", "*.apps.": "*.apps", "Dev.Apps": "*.dev.apps"}
+	for name, expected := range tests {
+		if actual := wildcardName(name); actual != expected {
+			t.Errorf("Expected wildcard <%s> for <%s>; but was <%s>", expected, name, actual)
+		}
+	}
+}
+
+func TestWildcardParent(t *testing.T) {
+	if parent, ok := wildcardParent("*"); !ok || parent != apexName {
+		t.Errorf("Expected parent <%s>; but was <%s>", apexName, parent)
+	}
+	if parent, ok := wildcardParent("*.apps"); !ok || parent != "apps" {
+		t.Errorf("Expected parent <apps>; but was <%s>", parent)
+	}
+	if _, ok := wildcardParent("web"); ok {
+		t.Errorf("Expected web not to be a wildcard")
+	}
+}
+
+func TestCollectZoneRecordsWildcardAndApex(t *testing.T) {
+	conf := &config.NXConfig{Namespaces: config.NamespaceConfig{DNS: config.DNSNamespaceConfig{Primaries: []config.PrimaryConfig{
+		{Name: "ns1", Zones: []string{"example.com"}},
+	}}}}
+	prefix := model.IPAMPrefix{Prefix: "10.0.0.0/24", Tags: []model.Tag{
+		{Name: "nx:dns:enable[true]"}, {Name: "nx:dns:forward_zone[example.com]"},
+	}}
+	addresses := []model.IPAddress{
+		{ID: 1, Address: "10.0.0.5/24", DnsName: "ingress", Prefix: &prefix, Tags: []model.Tag{
+			{Name: "nx:dns:wildcard[apps]"}, {Name: "nx:dns:apex[true]"},
+		}},
+	}
+
+	zoneRecordsMap, _ := collectZoneRecords(addresses, conf)
+	expected := []resourceRecord{
+		{Name: "ingress", Type: A, RData: "10.0.0.5", AddressID: 1},
+		{Name: "@", Type: A, RData: "10.0.0.5", AddressID: 1},
+		{Name: "*.apps", Type: A, RData: "10.0.0.5", AddressID: 1},
+	}
+	if diff := deep.Equal(zoneRecordsMap["example.com"], expected); diff != nil {
+		t.Error(diff)
+	}
+}
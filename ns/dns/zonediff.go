@@ -0,0 +1,238 @@
+package dns
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sort"
+	"strings"
+)
+
+// recordsStateFile keeps the records of the previous run to compute the changes of the next one
+const recordsStateFile = "generated/zone-records.json"
+const changesReportFile = "generated/zone-changes.json"
+const changesTextFile = "generated/zone-changes.txt"
+
+type changeKind string
+
+const (
+	changeAdded   changeKind = "added"
+	changeRemoved changeKind = "removed"
+	// changeChanged is a record of the same NetBox address with a new RData
+	changeChanged changeKind = "changed"
+)
+
+type storedZone struct {
+	Zone    string           `json:"zone"`
+	View    string           `json:"view,omitempty"`
+	Records []resourceRecord `json:"records"`
+}
+
+type recordChange struct {
+	Kind      changeKind `json:"kind"`
+	Name      string     `json:"name"`
+	Type      rrType     `json:"type"`
+	OldRData  string     `json:"old_rdata,omitempty"`
+	NewRData  string     `json:"new_rdata,omitempty"`
+	AddressID int        `json:"netbox_address_id,omitempty"`
+	NetboxURL string     `json:"netbox_url,omitempty"`
+}
+
+type zoneChanges struct {
+	Zone    string         `json:"zone"`
+	View    string         `json:"view,omitempty"`
+	Changes []recordChange `json:"changes"`
+}
+
+// changeReport lists the record changes of all zones since the previous run
+type changeReport struct {
+	GeneratedAt string        `json:"generated_at"`
+	Zones       []zoneChanges `json:"zones"`
+}
+
+// netboxAddressURL returns the web URL of the NetBox address, the configured URL points to the API
+func netboxAddressURL(netboxURL string, addressID int) string {
+	if addressID == 0 || len(netboxURL) == 0 {
+		return ""
+	}
+
+	base := strings.TrimSuffix(strings.TrimSuffix(netboxURL, "/"), "/api")
+	return fmt.Sprintf("%s/ipam/ip-addresses/%d/", base, addressID)
+}
+
+// diffRecords returns the changes from the old to the new records. A removed and an added record with the same name,
+// type and NetBox address are reported as one changed record.
+func diffRecords(oldRecords, newRecords []resourceRecord) []recordChange {
+	var removed, added []resourceRecord
+	for _, record := range oldRecords {
+		if !containsRecord(newRecords, record) {
+			removed = append(removed, record)
+		}
+	}
+	for _, record := range newRecords {
+		if !containsRecord(oldRecords, record) {
+			added = append(added, record)
+		}
+	}
+
+	var changes []recordChange
+	paired := make(map[int]bool)
+	for _, old := range removed {
+		change := recordChange{Kind: changeRemoved, Name: old.Name, Type: old.Type, OldRData: old.RData, AddressID: old.AddressID}
+		for idx, record := range added {
+			if !paired[idx] && record.Name == old.Name && record.Type == old.Type && record.AddressID == old.AddressID {
+				paired[idx] = true
+				change.Kind = changeChanged
+				change.NewRData = record.RData
+				break
+			}
+		}
+		changes = append(changes, change)
+	}
+	for idx, record := range added {
+		if !paired[idx] {
+			changes = append(changes, recordChange{Kind: changeAdded, Name: record.Name, Type: record.Type, NewRData: record.RData, AddressID: record.AddressID})
+		}
+	}
+
+	return changes
+}
+
+// diffZones compares the zones with the zones of the previous run, zones without changes are left out
+func diffZones(previous []storedZone, zones []Zone, netboxURL string) []zoneChanges {
+	key := func(zone, view string) string {
+		return fmt.Sprintf("%s/%s", zone, view)
+	}
+
+	previousRecords := make(map[string]storedZone)
+	for _, zone := range previous {
+		previousRecords[key(zone.Zone, zone.View)] = zone
+	}
+
+	var result []zoneChanges
+	add := func(zone, view string, changes []recordChange) {
+		if len(changes) == 0 {
+			return
+		}
+		for i := range changes {
+			changes[i].NetboxURL = netboxAddressURL(netboxURL, changes[i].AddressID)
+		}
+		result = append(result, zoneChanges{Zone: zone, View: view, Changes: changes})
+	}
+
+	for _, zone := range zones {
+		old := previousRecords[key(zone.Name, zone.View)]
+		delete(previousRecords, key(zone.Name, zone.View))
+		add(zone.Name, zone.View, diffRecords(old.Records, zone.Records))
+	}
+	for _, zone := range previous {
+		if _, ok := previousRecords[key(zone.Zone, zone.View)]; ok {
+			add(zone.Zone, zone.View, diffRecords(zone.Records, nil))
+		}
+	}
+
+	sort.SliceStable(result, func(i, j int) bool {
+		if result[i].Zone != result[j].Zone {
+			return result[i].Zone < result[j].Zone
+		}
+		return result[i].View < result[j].View
+	})
+	return result
+}
+
+// formatChanges renders the changes in a human-readable form
+func formatChanges(zones []zoneChanges) string {
+	var text strings.Builder
+	for _, zone := range zones {
+		if len(zone.View) > 0 {
+			text.WriteString(fmt.Sprintf("%s (view %s)\n", zone.Zone, zone.View))
+		} else {
+			text.WriteString(fmt.Sprintf("%s\n", zone.Zone))
+		}
+
+		for _, change := range zone.Changes {
+			switch change.Kind {
+			case changeAdded:
+				text.WriteString(fmt.Sprintf("  + %s %s %s", change.Name, change.Type, change.NewRData))
+			case changeRemoved:
+				text.WriteString(fmt.Sprintf("  - %s %s %s", change.Name, change.Type, change.OldRData))
+			case changeChanged:
+				text.WriteString(fmt.Sprintf("  ~ %s %s %s → %s", change.Name, change.Type, change.OldRData, change.NewRData))
+			}
+			if len(change.NetboxURL) > 0 {
+				text.WriteString(fmt.Sprintf(" (%s)", change.NetboxURL))
+			} else if change.AddressID != 0 {
+				text.WriteString(fmt.Sprintf(" (NetBox address %d)", change.AddressID))
+			}
+			text.WriteString("\n")
+		}
+	}
+
+	return text.String()
+}
+
+// readRecordsState returns the zones of the previous run, false if there was none
+func readRecordsState() ([]storedZone, bool) {
+	stateBytes, err := os.ReadFile(recordsStateFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, false
+	}
+	if err != nil {
+		panic(err)
+	}
+
+	var zones []storedZone
+	err = json.Unmarshal(stateBytes, &zones)
+	if err != nil {
+		logger.Printf("Could not read the records of the previous run from %s: %v", recordsStateFile, err)
+		return nil, false
+	}
+
+	return zones, true
+}
+
+// SaveRecordsState stores the records of the zones as the previous run for the next change report. It must only be
+// called once the zones have been written and pushed successfully.
+func SaveRecordsState(zones []Zone) {
+	stored := make([]storedZone, 0, len(zones))
+	for _, zone := range zones {
+		stored = append(stored, storedZone{Zone: zone.Name, View: zone.View, Records: zone.Records})
+	}
+
+	stateBytes, err := json.Marshal(stored)
+	if err != nil {
+		panic(err)
+	}
+	err = os.WriteFile(recordsStateFile, stateBytes, 0644)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// writeChangeReport writes the record changes since the previous run. Without records of a previous run the report is
+// empty.
+func writeChangeReport(zones []Zone, generatedAt string, netboxURL string) {
+	report := changeReport{GeneratedAt: generatedAt, Zones: []zoneChanges{}}
+	if previous, ok := readRecordsState(); ok {
+		if changes := diffZones(previous, zones, netboxURL); changes != nil {
+			report.Zones = changes
+		}
+	} else {
+		logger.Printf("No records of a previous run found, the zone change report is empty")
+	}
+
+	reportBytes, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		panic(err)
+	}
+	err = os.WriteFile(changesReportFile, reportBytes, 0644)
+	if err != nil {
+		panic(err)
+	}
+	err = os.WriteFile(changesTextFile, []byte(formatChanges(report.Zones)), 0644)
+	if err != nil {
+		panic(err)
+	}
+}
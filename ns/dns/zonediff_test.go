@@ -0,0 +1,62 @@
+package dns
+
+import (
+	"testing"
+
+	"github.com/go-test/deep"
+)
+
+func TestDiffRecords(t *testing.T) {
+	oldRecords := []resourceRecord{
+		{Name: "web01", Type: A, RData: "10.0.0.5", AddressID: 12},
+		{Name: "old", Type: A, RData: "10.0.0.9", AddressID: 4},
+		{Name: "www", Type: CName, RData: "web01", AddressID: 12},
+	}
+	newRecords := []resourceRecord{
+		{Name: "web01", Type: A, RData: "10.0.0.6", AddressID: 12},
+		{Name: "www", Type: CName, RData: "web01", AddressID: 12},
+		{Name: "new", Type: A, RData: "10.0.0.9", AddressID: 5},
+	}
+
+	expected := []recordChange{
+		{Kind: changeChanged, Name: "web01", Type: A, OldRData: "10.0.0.5", NewRData: "10.0.0.6", AddressID: 12},
+		{Kind: changeRemoved, Name: "old", Type: A, OldRData: "10.0.0.9", AddressID: 4},
+		{Kind: changeAdded, Name: "new", Type: A, NewRData: "10.0.0.9", AddressID: 5},
+	}
+	if diff := deep.Equal(diffRecords(oldRecords, newRecords), expected); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestDiffZones(t *testing.T) {
+	previous := []storedZone{
+		{Zone: "example.com", Records: []resourceRecord{{Name: "web01", Type: A, RData: "10.0.0.5", AddressID: 12}}},
+		{Zone: "example.org", Records: []resourceRecord{{Name: "web", Type: A, RData: "192.0.2.5", AddressID: 7}}},
+		{Zone: "example.net", Records: []resourceRecord{{Name: "mail", Type: A, RData: "192.0.2.25"}}},
+	}
+	zones := []Zone{
+		{Name: "example.com", Records: []resourceRecord{{Name: "web01", Type: A, RData: "10.0.0.6", AddressID: 12}}},
+		{Name: "example.org", Records: []resourceRecord{{Name: "web", Type: A, RData: "192.0.2.5", AddressID: 7}}},
+	}
+
+	expected := []zoneChanges{
+		{Zone: "example.com", Changes: []recordChange{
+			{Kind: changeChanged, Name: "web01", Type: A, OldRData: "10.0.0.5", NewRData: "10.0.0.6", AddressID: 12, NetboxURL: "https://netbox.example.com/ipam/ip-addresses/12/"},
+		}},
+		{Zone: "example.net", Changes: []recordChange{
+			{Kind: changeRemoved, Name: "mail", Type: A, OldRData: "192.0.2.25"},
+		}},
+	}
+	changes := diffZones(previous, zones, "https://netbox.example.com/api")
+	if diff := deep.Equal(changes, expected); diff != nil {
+		t.Error(diff)
+	}
+
+	expectedText := "example.com\n" +
+		"  ~ web01 A 10.0.0.5 → 10.0.0.6 (https://netbox.example.com/ipam/ip-addresses/12/)\n" +
+		"example.net\n" +
+		"  - mail A 192.0.2.25\n"
+	if actual := formatChanges(changes); actual != expectedText {
+		t.Errorf("Expected <%s>; but was <%s>", expectedText, actual)
+	}
+}
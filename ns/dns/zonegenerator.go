@@ -5,6 +5,7 @@ import (
 	"log"
 	"net"
 	"os"
+	"path/filepath"
 	"peg.nu/nx/model"
 	"regexp"
 	"strings"
@@ -31,6 +32,15 @@ type SOAInfo struct {
 	Serial                string
 }
 
+// Zone is a zone computed from the NetBox addresses, as seen from View if the primary of the zone has views
+type Zone struct {
+	Name     string
+	View     string
+	SOAInfo  SOAInfo
+	Includes []string
+	Records  []resourceRecord
+}
+
 type DNSIP struct {
 	IP *model.IPAddress
 
@@ -38,23 +48,79 @@ type DNSIP struct {
 	ReverseZoneName string   `nx:"reverse_zone,ns:dns"`
 	ForwardZoneName string   `nx:"forward_zone,ns:dns"`
 	CNames          []string `nx:"cname,ns:dns"`
+	Views           []string `nx:"view,ns:dns"`
+	DNS64Prefix     string   `nx:"dns64,ns:dns"`
+	V6AliasPattern  string   `nx:"v6_alias,ns:dns"`
+	Wildcards       []string `nx:"wildcard,ns:dns"`
+	Apex            bool     `nx:"apex,ns:dns"`
+}
+
+// DefaultFallbackNamePattern is the name of addresses without a name, {ip} is replaced by the IP address with all dots
+// and colons replaced by dashes
+const DefaultFallbackNamePattern = "ip-{ip}"
+
+// NamingOptions controls how FixFlattenAddress names addresses
+type NamingOptions struct {
+	// Zones are the configured zones, the most specific matching one is used as the forward zone
+	Zones []string
+	// FallbackNamePattern is used for addresses without a name, DefaultFallbackNamePattern if empty
+	FallbackNamePattern string
 }
 
-var unknownNameCounter = 1
+// NewNamingOptions returns the naming options of the DNS namespace config
+func NewNamingOptions(conf *config.NXConfig) NamingOptions {
+	return NamingOptions{
+		Zones:               configuredZones(conf),
+		FallbackNamePattern: conf.Namespaces.DNS.FallbackNamePattern,
+	}
+}
 
-func FixFlattenAddress(address *DNSIP) {
+// FQDN returns the fully qualified name of the address without trailing dot, which is the forward zone for the apex
+func (d DNSIP) FQDN() string {
+	if d.IP.GetName() == apexName {
+		return d.ForwardZoneName
+	}
+
+	return fmt.Sprintf("%s.%s", d.IP.GetName(), d.ForwardZoneName)
+}
+
+// fallbackName returns a name derived from the IP of the address
+func fallbackName(address string, pattern string) string {
+	if len(pattern) == 0 {
+		pattern = DefaultFallbackNamePattern
+	}
+
+	ip, _, err := net.ParseCIDR(address)
+	ipString := address
+	if err == nil {
+		ipString = ip.String()
+	}
+
+	dashed := strings.NewReplacer(".", "-", ":", "-").Replace(ipString)
+	return strings.ReplaceAll(pattern, "{ip}", dashed)
+}
+
+// FixFlattenAddress normalizes the name of the address and assigns it to the most specific of the configured zones. If
+// none of the zones match, the forward zone is cut down to its last two labels.
+func FixFlattenAddress(address *DNSIP, opts NamingOptions) {
 	originalName := address.IP.GetName()
 	// remove everything after the first space
 	address.IP.DnsName = strings.Split(strings.ToLower(originalName), " ")[0]
 	if len(address.IP.GetName()) == 0 {
-		address.IP.DnsName = fmt.Sprintf("unknown-static-%v", unknownNameCounter)
-		unknownNameCounter++
+		address.IP.DnsName = fallbackName(address.IP.Address, opts.FallbackNamePattern)
 	}
 
 	originalZone := address.ForwardZoneName
 	if len(originalZone) == 0 {
 		return
 	}
+
+	if zone, name, ok := findMostSpecificZone(address.IP.GetName(), originalZone, opts.Zones); ok {
+		address.ForwardZoneName = zone
+		address.IP.DnsName = name
+		return
+	}
+
 	zoneParts := strings.Split(originalZone, ".")
 	cutoff := ""
 	shortZone := originalZone
@@ -78,6 +144,100 @@ func FixFlattenAddress(address *DNSIP) {
 	//logger.Printf("%s -> (%s).%s\n", originalName, address.IP.Name, shortZone)
 }
 
+// normalizeAddress parses the tags of the address and normalizes its name by FixFlattenAddress and the hostname policy.
+// It returns false for addresses which are not part of the zones.
+func normalizeAddress(address *model.IPAddress, namingOptions NamingOptions, policy hostnamePolicy, conf *config.NXConfig, sanitizations *[]nameSanitization) (DNSIP, bool) {
+	dnsIP := DNSIP{IP: address}
+	tagparser.ParseTags(&dnsIP, address.Tags, address.Prefix.Tags)
+
+	if !dnsIP.Enabled {
+		return dnsIP, false
+	}
+	if len(dnsIP.V6AliasPattern) > 0 && !isValidV6AliasPattern(dnsIP.V6AliasPattern) {
+		logger.Printf("Ignoring v6 alias pattern <%s> of address %d, it does not contain %s", dnsIP.V6AliasPattern, address.ID, v6AliasPlaceholder)
+		dnsIP.V6AliasPattern = ""
+	}
+	if conf.Namespaces.DNS.SkipNameless && len(strings.TrimSpace(address.GetName())) == 0 {
+		return dnsIP, false
+	}
+
+	FixFlattenAddress(&dnsIP, namingOptions)
+	return dnsIP, sanitizeAddress(&dnsIP, policy, sanitizations)
+}
+
+// NormalizedAddresses returns the DNS enabled addresses with their names normalized exactly as they are used in the
+// zones. Only addresses with a forward record in the zones are returned, CNAMEs without a record in the zones are
+// removed, so addresses and CNAMEs dropped by the conflict policy are left out as well.
+func NormalizedAddresses(addresses []model.IPAddress, zones []Zone, conf *config.NXConfig) []DNSIP {
+	var namingOptions = NewNamingOptions(conf)
+	var policy = parseHostnamePolicy(conf.Namespaces.DNS.HostnamePolicy)
+	// the sanitizations are reported by the zone generation
+	var sanitizations []nameSanitization
+
+	zoneRecords := make(map[string][]resourceRecord)
+	for _, zone := range zones {
+		zoneRecords[zone.Name] = append(zoneRecords[zone.Name], zone.Records...)
+	}
+
+	var normalized []DNSIP
+	for _, address := range addresses {
+		dnsIP, ok := normalizeAddress(&address, namingOptions, policy, conf, &sanitizations)
+		if !ok || len(dnsIP.ForwardZoneName) == 0 {
+			continue
+		}
+
+		records := zoneRecords[dnsIP.ForwardZoneName]
+		if !hasRecordOf(records, address.ID, address.GetName(), A, Aaaa) {
+			continue
+		}
+		var cnames []string
+		for _, cname := range dnsIP.CNames {
+			if hasRecordOf(records, address.ID, cname, CName) {
+				cnames = append(cnames, cname)
+			}
+		}
+		dnsIP.CNames = cnames
+
+		normalized = append(normalized, dnsIP)
+	}
+
+	return normalized
+}
+
+// hasRecordOf returns true if the records contain a record of the address with the name and one of the types
+func hasRecordOf(records []resourceRecord, addressID int, name string, types ...rrType) bool {
+	for _, record := range records {
+		if record.AddressID != addressID || record.Name != name {
+			continue
+		}
+		for _, recordType := range types {
+			if record.Type == recordType {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// findMostSpecificZone returns the longest of the zones containing the name within the forward zone, together with the
+// name relative to that zone
+func findMostSpecificZone(name, forwardZone string, zones []string) (string, string, bool) {
+	forwardZone = normalizeZoneName(forwardZone)
+	fqdn := strings.TrimRight(name, ".")
+	if !strings.HasSuffix(fqdn, "."+forwardZone) {
+		fqdn = fmt.Sprintf("%s.%s", fqdn, forwardZone)
+	}
+
+	// a name equal to a zone is its apex, records of the parent zone would be hidden by the zone cut
+	zone, ok := findEnclosingZone(fqdn, zones)
+	if !ok {
+		return "", "", false
+	}
+
+	return zone, relativeName(fqdn, zone), true
+}
+
 type rrType string
 
 const (
@@ -89,18 +249,31 @@ const (
 	CName rrType = "CNAME"
 	// Ptr represents the RR type "PTR" for a reverse entry
 	Ptr rrType = "PTR"
+	// NS represents the RR type "NS" for a delegation to another nameserver
+	NS rrType = "NS"
+	// DS represents the RR type "DS" for the chain of trust of a signed, delegated zone
+	DS rrType = "DS"
 )
 
 type resourceRecord struct {
-	Name  string
-	Type  rrType
-	RData string
+	Name  string `json:"name"`
+	Type  rrType `json:"type"`
+	RData string `json:"rdata"`
+	// AddressID is the id of the NetBox address the record originates from, 0 for records from the config
+	AddressID int `json:"netbox_address_id,omitempty"`
+	// View is the view the record is visible in, empty for all views
+	View string `json:"view,omitempty"`
+}
+
+func (r resourceRecord) sameData(other resourceRecord) bool {
+	return r.Name == other.Name && r.Type == other.Type && r.RData == other.RData
 }
 
 type templateArguments struct {
 	SOAInfo     SOAInfo
 	Records     []resourceRecord
 	ZoneName    string
+	View        string
 	GeneratedAt string
 	Includes    []string
 }
@@ -115,6 +288,19 @@ func putMap(theMap map[string][]resourceRecord, key string, value resourceRecord
 	}
 }
 
+// putViewRecord puts the record once for every view, or once for all views if views is empty
+func putViewRecord(theMap map[string][]resourceRecord, key string, value resourceRecord, views []string) {
+	if len(views) == 0 {
+		putMap(theMap, key, value)
+		return
+	}
+
+	for _, view := range views {
+		value.View = view
+		putMap(theMap, key, value)
+	}
+}
+
 func ipToNibble(cidr string, minimal bool) (string, bool, error) {
 	ip, ipNet, err := net.ParseCIDR(cidr)
 	if err != nil {
@@ -144,27 +330,34 @@ func ipToNibble(cidr string, minimal bool) (string, bool, error) {
 	return fmt.Sprintf("%s.ip6.arpa", strings.Join(reverse, ".")), isIP4, nil
 }
 
-// GenerateZones generates the BIND zonefiles
-func GenerateZones(addresses []model.IPAddress, defaultSoaInfo SOAInfo, conf *config.NXConfig) []string {
-	t := time.Now()
-
-	if len(defaultSoaInfo.Serial) == 0 {
-		atMidnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.Local).Unix()
-		iteration := (t.Unix() - atMidnight) / (60 * 2)
-
-		defaultSoaInfo.Serial = fmt.Sprintf("%02d%02d%02d%03d", t.Year()-2000, t.Month(), t.Day(), iteration)
-	}
-
+// collectZoneRecords computes the records of all zones from the given addresses and the configured delegations
+func collectZoneRecords(addresses []model.IPAddress, conf *config.NXConfig) (map[string][]resourceRecord, []nameSanitization) {
 	var zoneRecordsMap = make(map[string][]resourceRecord)
+	var taggedNameservers = make(map[string][]delegationNameserver)
+	var classlessCNames = make(map[string][]resourceRecord)
+	var dns64Records = make(map[string][]resourceRecord)
+	var reverseCandidates = autoReverseCandidates(conf)
+	var unconfiguredReverseZones = make(map[string]bool)
+	var namingOptions = NewNamingOptions(conf)
+	var policy = parseHostnamePolicy(conf.Namespaces.DNS.HostnamePolicy)
+	var sanitizations []nameSanitization
 	for _, address := range addresses {
-		dnsIP := DNSIP{IP: &address}
-		tagparser.ParseTags(&dnsIP, address.Tags, address.Prefix.Tags)
+		for _, delegatedZone := range prefixDelegations(&address) {
+			delegatedZone = normalizeZoneName(delegatedZone)
+			if _, ok := taggedNameservers[delegatedZone]; !ok {
+				taggedNameservers[delegatedZone] = nil
+			}
+		}
 
-		if !dnsIP.Enabled {
+		dnsIP, ok := normalizeAddress(&address, namingOptions, policy, conf, &sanitizations)
+		if !ok {
 			continue
 		}
 
-		FixFlattenAddress(&dnsIP)
+		isDerivedReverseZone := false
+		if len(dnsIP.ReverseZoneName) == 0 {
+			dnsIP.ReverseZoneName, isDerivedReverseZone = deriveReverseZone(address, reverseCandidates, autoReverseRounding(dnsIP.ForwardZoneName, conf))
+		}
 
 		ip, _, _ := net.ParseCIDR(address.Address)
 		isIP4 := strings.Count(ip.String(), ":") < 2
@@ -177,26 +370,78 @@ func GenerateZones(addresses []model.IPAddress, defaultSoaInfo SOAInfo, conf *co
 				recordType = Aaaa
 			}
 
-			putMap(zoneRecordsMap, dnsIP.ForwardZoneName, resourceRecord{
+			putViewRecord(zoneRecordsMap, dnsIP.ForwardZoneName, resourceRecord{
+				Name:      address.GetName(),
+				Type:      recordType,
+				RData:     ip.String(),
+				AddressID: address.ID,
+			}, dnsIP.Views)
+
+			if dnsIP.Apex {
+				putViewRecord(zoneRecordsMap, dnsIP.ForwardZoneName, resourceRecord{
+					Name:      apexName,
+					Type:      recordType,
+					RData:     ip.String(),
+					AddressID: address.ID,
+				}, dnsIP.Views)
+			}
+
+			for _, wildcard := range dnsIP.Wildcards {
+				putViewRecord(zoneRecordsMap, dnsIP.ForwardZoneName, resourceRecord{
+					Name:      wildcardName(wildcard),
+					Type:      recordType,
+					RData:     ip.String(),
+					AddressID: address.ID,
+				}, dnsIP.Views)
+			}
+
+			if isIP4 && len(dnsIP.DNS64Prefix) > 0 {
+				synthesized, err := dns64Address(dnsIP.DNS64Prefix, ip)
+				if err != nil {
+					logger.Printf("Could not synthesize AAAA record of %v: %v", address, err)
+				} else {
+					putViewRecord(dns64Records, dnsIP.ForwardZoneName, resourceRecord{
+						Name:      address.GetName(),
+						Type:      Aaaa,
+						RData:     synthesized.String(),
+						AddressID: address.ID,
+					}, dnsIP.Views)
+				}
+			}
 
-				Name:  address.GetName(),
-				Type:  recordType,
-				RData: ip.String(),
-			})
+			if !isIP4 && len(dnsIP.V6AliasPattern) > 0 && address.GetName() != apexName {
+				alias := applyHostnamePolicy(v6AliasName(dnsIP.V6AliasPattern, address.GetName()), dnsIP.ForwardZoneName, address.ID, policy, &sanitizations)
+				if len(alias) > 0 {
+					putViewRecord(zoneRecordsMap, dnsIP.ForwardZoneName, resourceRecord{
+						Name:      alias,
+						Type:      Aaaa,
+						RData:     ip.String(),
+						AddressID: address.ID,
+					}, dnsIP.Views)
+				}
+			}
 
 			for _, cname := range dnsIP.CNames {
-				putMap(zoneRecordsMap, dnsIP.ForwardZoneName, resourceRecord{
-					Name:  cname,
-					Type:  CName,
-					RData: address.GetName(),
+				putViewRecord(zoneRecordsMap, dnsIP.ForwardZoneName, resourceRecord{
+					Name:      cname,
+					Type:      CName,
+					RData:     address.GetName(),
+					AddressID: address.ID,
+				}, dnsIP.Views)
+			}
+
+			for _, delegatedZone := range addressDelegations(&address) {
+				putNameserver(taggedNameservers, delegatedZone, delegationNameserver{
+					Name: dnsIP.FQDN(),
+					IPs:  []string{ip.String()},
 				})
 			}
 		}
 
 		if len(dnsIP.ReverseZoneName) > 0 {
-			zoneName, reverseZoneV4, err := ipToNibble(dnsIP.ReverseZoneName, true)
+			reverse, err := parseReverseZone(dnsIP.ReverseZoneName)
 			if err != nil {
-				logger.Printf("Could not parse cidr <%v> of %v", dnsIP.ReverseZoneName, address)
+				logger.Printf("Could not use reverse zone <%v> of %v: %v", dnsIP.ReverseZoneName, address, err)
 				continue
 			}
 
@@ -205,27 +450,121 @@ func GenerateZones(addresses []model.IPAddress, defaultSoaInfo SOAInfo, conf *co
 				tagparser.ParseTags(&dnsIP, address.Prefix.Tags, []model.Tag{})
 			}
 
-			name, addressV4, err := ipToNibble(address.Address, false)
-			if err != nil {
-				logger.Printf("Could not parse cidr <%v> of %v", address.Address, address)
+			if reverse.IsIP4 != isIP4 {
+				logger.Printf("IP to reverse zone family mismatch! IP: %v (isV4: %v), Reverse Zone: %v (isV4: %v)", address.Address, isIP4, dnsIP.ReverseZoneName, reverse.IsIP4)
 				continue
 			}
 
-			if reverseZoneV4 != addressV4 {
-				logger.Printf("IP to reverse zone family mismatch! IP: %v (isV4: %v), Reverse Zone: %v (isV4: %v)", address.Address, addressV4, dnsIP.ReverseZoneName, reverseZoneV4)
-				continue
+			if isDerivedReverseZone && !util.SliceContainsString(namingOptions.Zones, reverse.Name) && !unconfiguredReverseZones[reverse.Name] {
+				logger.Printf("Derived reverse zone %s of %s is not configured for any primary", reverse.Name, address.Address)
+				unconfiguredReverseZones[reverse.Name] = true
 			}
 
-			name = name[:len(name)-len(zoneName)-1]
+			name, err := reverse.ptrName(address.Address)
+			if err != nil {
+				logger.Printf("Could not determine PTR name of %v: %v", address, err)
+				continue
+			}
 
-			rData := fmt.Sprintf("%s.%s", address.GetName(), dnsIP.ForwardZoneName)
-			rData = strings.TrimRight(rData, ".")
+			rData := strings.TrimRight(dnsIP.FQDN(), ".")
 			rData = rData + "."
-			putMap(zoneRecordsMap, zoneName, resourceRecord{
-				Name:  name,
-				Type:  Ptr,
-				RData: rData,
-			})
+			putViewRecord(zoneRecordsMap, reverse.Name, resourceRecord{
+				Name:      name,
+				Type:      Ptr,
+				RData:     rData,
+				AddressID: address.ID,
+			}, dnsIP.Views)
+
+			if len(reverse.ClasslessParent) > 0 {
+				putViewRecord(classlessCNames, reverse.ClasslessParent, resourceRecord{
+					Name:      name,
+					Type:      CName,
+					RData:     fmt.Sprintf("%s.%s.", name, reverse.Name),
+					AddressID: address.ID,
+				}, dnsIP.Views)
+			}
+		}
+	}
+
+	addDNS64Records(zoneRecordsMap, dns64Records)
+
+	for parent, cnames := range classlessCNames {
+		if !util.SliceContainsString(namingOptions.Zones, parent) {
+			logger.Printf("Parent zone %s of classless reverse zones is not configured, its %d CNAME record(s) have to be added by its operator", parent, len(cnames))
+			continue
+		}
+		for _, cname := range cnames {
+			if !containsRecord(zoneRecordsMap[parent], cname) {
+				putMap(zoneRecordsMap, parent, cname)
+			}
+		}
+	}
+
+	addDelegationRecords(zoneRecordsMap, collectDelegations(conf, taggedNameservers), namingOptions.Zones)
+
+	// configured zones without any records are still generated with their SOA, NS and includes
+	for _, zone := range namingOptions.Zones {
+		if _, ok := zoneRecordsMap[zone]; !ok {
+			zoneRecordsMap[zone] = nil
+		}
+	}
+
+	return zoneRecordsMap, sanitizations
+}
+
+// applyPrimarySettings sets the SOA info and the includes of the primary of every zone
+func applyPrimarySettings(zones []Zone, defaultSoaInfo SOAInfo, conf *config.NXConfig) {
+	for i := range zones {
+		zone := &zones[i]
+		zone.SOAInfo = defaultSoaInfo
+		zone.Includes = nil
+
+		primaryConf := util.FindPrimaryForZone(*conf, zone.Name)
+		if primaryConf == nil {
+			continue
+		}
+
+		zone.SOAInfo.DottedMailResponsible = primaryConf.DottedEmail
+		zone.SOAInfo.NameserverFQDN = fmt.Sprintf("%s.", primaryConf.Name)
+		for _, include := range primaryConf.Includes {
+			if include.Zone == zone.Name {
+				zone.Includes = append(zone.Includes, include.IncludeFiles...)
+			}
+		}
+	}
+}
+
+// GenerateZones generates the BIND zonefiles and returns the generated zones
+func GenerateZones(addresses []model.IPAddress, defaultSoaInfo SOAInfo, conf *config.NXConfig) []Zone {
+	t := time.Now()
+
+	if len(defaultSoaInfo.Serial) == 0 {
+		atMidnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.Local).Unix()
+		iteration := (t.Unix() - atMidnight) / (60 * 2)
+
+		defaultSoaInfo.Serial = fmt.Sprintf("%02d%02d%02d%03d", t.Year()-2000, t.Month(), t.Day(), iteration)
+	}
+
+	checkDnssecSupport(conf)
+
+	zoneRecordsMap, sanitizations := collectZoneRecords(addresses, conf)
+	zones := splitViews(zoneRecordsMap, conf)
+	applyPrimarySettings(zones, defaultSoaInfo, conf)
+
+	conflicts, conflictErr := resolveConflicts(zones, parseConflictPolicy(conf.Namespaces.DNS.ConflictPolicy))
+	sortZoneRecords(zones)
+	writeReport(generationReport{
+		GeneratedAt:   t.Format(time.RFC3339),
+		Conflicts:     conflicts,
+		Sanitizations: sanitizations,
+		DNSSEC:        dnssecReport(zones, conf),
+	})
+	if conflictErr != nil {
+		panic(conflictErr)
+	}
+	for _, sanitization := range sanitizations {
+		if sanitization.Action == actionFailed {
+			panic(fmt.Errorf("hostname %s of address %d is invalid: %s", sanitization.Original, sanitization.AddressID, strings.Join(sanitization.Reasons, ", ")))
 		}
 	}
 
@@ -244,41 +583,68 @@ func GenerateZones(addresses []model.IPAddress, defaultSoaInfo SOAInfo, conf *co
 	}
 	cw := cache.New(zoneTemplate, ignoreRegexes, true)
 
-	for zone, records := range zoneRecordsMap {
-		templateArgs.Records = records
-		templateArgs.ZoneName = zone
+	var staged []stagedFile
+	for _, zone := range zones {
+		if zoneOutput(zone.Name, conf) != outputFiles {
+			continue
+		}
 
-		soaInfo := defaultSoaInfo
-		primaryConf := util.FindPrimaryForZone(*conf, zone)
-		if primaryConf != nil {
-			soaInfo.DottedMailResponsible = primaryConf.DottedEmail
-			soaInfo.NameserverFQDN = fmt.Sprintf("%s.", primaryConf.Name)
+		templateArgs.Records = zone.Records
+		templateArgs.ZoneName = zone.Name
+		templateArgs.View = zone.View
+		templateArgs.Includes = zone.Includes
+		templateArgs.SOAInfo = zone.SOAInfo
 
-			var includes []string
-			for _, include := range primaryConf.Includes {
-				if include.Zone == zone {
-					includes = append(includes, include.IncludeFiles...)
-				}
-			}
-			templateArgs.Includes = includes
+		content, err := cw.RenderTemplate(templateArgs)
+		if err != nil {
+			panic(err)
 		}
-		templateArgs.SOAInfo = soaInfo
+		staged = append(staged, stagedFile{writer: cw, path: fmt.Sprintf("generated/zones/%s", zoneFileName(zone.Name, zone.View)), content: content})
+	}
+
+	catalogWriter, catalogFiles := renderCatalogZones(zones, defaultSoaInfo, templateArgs.GeneratedAt, conf)
+	staged = append(staged, catalogFiles...)
 
-		_, err := cw.WriteTemplate(
-			fmt.Sprintf("generated/zones/%s.db", zone),
-			templateArgs,
-		)
+	// nothing is written if any zone is invalid, so the files of the last valid run stay in place
+	contents := make(map[string]string)
+	for _, file := range staged {
+		contents[filepath.Base(file.path)] = string(file.content)
+	}
+	err = validateZoneContents(contents, "generated/zones")
+	if err != nil {
+		panic(err)
+	}
+	for _, file := range staged {
+		_, err := file.writer.WriteContent(file.path, file.content)
 		if err != nil {
 			panic(err)
 		}
 	}
 
-	util.CleanDirectoryExcept("generated/zones", cw.ProcessedFiles, conf)
+	util.CleanDirectoryExcept("generated/zones", append(cw.ProcessedFiles, catalogWriter.ProcessedFiles...), conf)
 	conf.UpdatedFiles = append(conf.UpdatedFiles, cw.UpdatedFiles...)
+	conf.UpdatedFiles = append(conf.UpdatedFiles, catalogWriter.UpdatedFiles...)
+
+	writeChangeReport(zones, t.Format(time.RFC3339), conf.Netbox.URL)
 
-	zones := make([]string, 0, len(zoneRecordsMap))
-	for key := range zoneRecordsMap {
-		zones = append(zones, key)
-	}
 	return zones
 }
+
+// stagedFile is a rendered file, which is only written once all zone files are valid
+type stagedFile struct {
+	writer  *cache.CachedTemplateWriter
+	path    string
+	content []byte
+}
+
+// ZoneNames returns the distinct names of the zones
+func ZoneNames(zones []Zone) []string {
+	var names []string
+	for _, zone := range zones {
+		if !util.SliceContainsString(names, zone.Name) {
+			names = append(names, zone.Name)
+		}
+	}
+
+	return names
+}
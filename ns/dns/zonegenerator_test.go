@@ -0,0 +1,54 @@
+package dns
+
+import (
+	"testing"
+
+	"github.com/go-test/deep"
+	"peg.nu/nx/config"
+	"peg.nu/nx/model"
+)
+
+func TestCollectZoneRecordsEmptyZones(t *testing.T) {
+	conf := &config.NXConfig{Namespaces: config.NamespaceConfig{DNS: config.DNSNamespaceConfig{Primaries: []config.PrimaryConfig{
+		{Name: "ns1", Zones: []string{"example.com", "empty.example.com"}, Views: []config.DNSView{{Name: "internal"}}},
+		{Name: "ns2", Zones: []string{"example.net"}},
+	}}}}
+	prefix := model.IPAMPrefix{Prefix: "10.0.0.0/24", Tags: []model.Tag{{Name: "nx:dns:enable[true]"}, {Name: "nx:dns:forward_zone[example.com]"}}}
+	addresses := []model.IPAddress{{ID: 1, Address: "10.0.0.5/24", DnsName: "web", Prefix: &prefix}}
+
+	zoneRecordsMap, _ := collectZoneRecords(addresses, conf)
+	expected := map[string][]resourceRecord{
+		"example.com":       {{Name: "web", Type: A, RData: "10.0.0.5", AddressID: 1}},
+		"empty.example.com": nil,
+		"example.net":       nil,
+	}
+	if diff := deep.Equal(zoneRecordsMap, expected); diff != nil {
+		t.Error(diff)
+	}
+
+	zones := splitViews(zoneRecordsMap, conf)
+	if diff := deep.Equal(ZoneNames(zones), []string{"empty.example.com", "example.com", "example.net"}); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestCollectZoneRecordsZoneNamedHost(t *testing.T) {
+	conf := &config.NXConfig{Namespaces: config.NamespaceConfig{DNS: config.DNSNamespaceConfig{Primaries: []config.PrimaryConfig{
+		{Name: "ns1", Zones: []string{"peg.nu", "bue39.peg.nu", "0.0.10.in-addr.arpa"}},
+	}}}}
+	prefix := model.IPAMPrefix{Prefix: "10.0.0.0/24", Tags: []model.Tag{
+		{Name: "nx:dns:enable[true]"}, {Name: "nx:dns:forward_zone[peg.nu]"}, {Name: "nx:dns:reverse_zone[10.0.0.0/24]"},
+	}}
+	addresses := []model.IPAddress{{ID: 1, Address: "10.0.0.39/24", DnsName: "bue39.peg.nu", Prefix: &prefix}}
+
+	zoneRecordsMap, _ := collectZoneRecords(addresses, conf)
+	if diff := deep.Equal(zoneRecordsMap["bue39.peg.nu"], []resourceRecord{{Name: "@", Type: A, RData: "10.0.0.39", AddressID: 1}}); diff != nil {
+		t.Error(diff)
+	}
+	if diff := deep.Equal(zoneRecordsMap["0.0.10.in-addr.arpa"], []resourceRecord{{Name: "39", Type: Ptr, RData: "bue39.peg.nu.", AddressID: 1}}); diff != nil {
+		t.Error(diff)
+	}
+	if len(zoneRecordsMap["peg.nu"]) != 0 {
+		t.Errorf("Expected no records hidden behind the zone cut in peg.nu; but was <%v>", zoneRecordsMap["peg.nu"])
+	}
+}
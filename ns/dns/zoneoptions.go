@@ -0,0 +1,83 @@
+package dns
+
+import (
+	"fmt"
+
+	"peg.nu/nx/config"
+)
+
+// addressMatchList formats the entries of an address match list, names of configured ACLs are quoted while addresses,
+// keywords and key references are used as they are
+func addressMatchList(entries []string, acls []config.BindACL) []string {
+	if entries == nil {
+		return nil
+	}
+
+	list := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		for _, acl := range acls {
+			if acl.Name == entry {
+				entry = fmt.Sprintf("%q", entry)
+				break
+			}
+		}
+		list = append(list, entry)
+	}
+
+	return list
+}
+
+// configuredAclLists returns the ACLs configured for the DNS namespace
+func configuredAclLists(acls []config.BindACL) []aclPrimaryList {
+	var lists []aclPrimaryList
+	for _, acl := range acls {
+		var entries []primaryIPAndPort
+		for _, entry := range addressMatchList(acl.Entries, acls) {
+			entries = append(entries, primaryIPAndPort{IP: entry})
+		}
+		lists = append(lists, aclPrimaryList{Type: listAcl, Name: acl.Name, Entries: entries})
+	}
+
+	return lists
+}
+
+// zoneOptions returns the options of the zone on the nameserver, the options of the zone replace the default options
+// of the nameserver while custom options of both are used
+func zoneOptions(primary *config.PrimaryConfig, zone string) config.BindZoneOptions {
+	options := primary.DefaultZoneOptions
+	zoneOptions, ok := primary.ZoneOptions[zone]
+	if !ok {
+		return options
+	}
+
+	if zoneOptions.AllowQuery != nil {
+		options.AllowQuery = zoneOptions.AllowQuery
+	}
+	if zoneOptions.AllowUpdate != nil {
+		options.AllowUpdate = zoneOptions.AllowUpdate
+	}
+	if len(zoneOptions.MaxJournalSize) > 0 {
+		options.MaxJournalSize = zoneOptions.MaxJournalSize
+	}
+	options.NotifyExplicit = options.NotifyExplicit || zoneOptions.NotifyExplicit
+	options.Custom = append(append([]string{}, options.Custom...), zoneOptions.Custom...)
+
+	return options
+}
+
+// applyZoneOptions sets the options of the zone configured for the nameserver
+func applyZoneOptions(zone *templateZone, primary *config.PrimaryConfig, acls []config.BindACL) {
+	options := zoneOptions(primary, zone.Name)
+
+	zone.AllowQuery = addressMatchList(options.AllowQuery, acls)
+	zone.NotifyExplicit = options.NotifyExplicit
+	zone.MaxJournalSize = options.MaxJournalSize
+	zone.CustomOptions = options.Custom
+	if !zone.IsSecondary {
+		// secondary zones can only be updated by their primary
+		zone.AllowUpdate = addressMatchList(options.AllowUpdate, acls)
+		if len(zone.AllowUpdate) > 0 {
+			logger.Printf("Zone %s of nameserver %s allows dynamic updates while nx rewrites its zone file, freeze it with rndc while the file is written or use the %s output", zone.Name, primary.Name, outputDynamicUpdate)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package dns
+
+import (
+	"testing"
+
+	"github.com/go-test/deep"
+	"peg.nu/nx/config"
+)
+
+var zoneOptionsTestACLs = []config.BindACL{
+	{Name: "trusted", Entries: []string{"10.0.0.0/8", "localhost"}},
+	{Name: "updaters", Entries: []string{"key ddns-key", "trusted"}},
+}
+
+func TestAddressMatchList(t *testing.T) {
+	expected := []string{`"trusted"`, "any", "!192.0.2.1", "key ddns-key"}
+	if diff := deep.Equal(addressMatchList([]string{"trusted", "any", "!192.0.2.1", "key ddns-key"}, zoneOptionsTestACLs), expected); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestConfiguredAclLists(t *testing.T) {
+	expected := []aclPrimaryList{
+		{Type: listAcl, Name: "trusted", Entries: []primaryIPAndPort{{IP: "10.0.0.0/8"}, {IP: "localhost"}}},
+		{Type: listAcl, Name: "updaters", Entries: []primaryIPAndPort{{IP: "key ddns-key"}, {IP: `"trusted"`}}},
+	}
+	if diff := deep.Equal(configuredAclLists(zoneOptionsTestACLs), expected); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestApplyZoneOptions(t *testing.T) {
+	primary := &config.PrimaryConfig{
+		DefaultZoneOptions: config.BindZoneOptions{AllowQuery: []string{"trusted"}, MaxJournalSize: "10m", Custom: []string{"zone-statistics full;"}},
+		ZoneOptions: map[string]config.BindZoneOptions{
+			"example.com": {AllowQuery: []string{"any"}, AllowUpdate: []string{"updaters"}, NotifyExplicit: true, Custom: []string{"check-names ignore;"}},
+			"example.net": {AllowUpdate: []string{"updaters"}},
+		},
+	}
+
+	zone := templateZone{Name: "example.com"}
+	applyZoneOptions(&zone, primary, zoneOptionsTestACLs)
+	expected := templateZone{
+		Name:           "example.com",
+		AllowQuery:     []string{"any"},
+		AllowUpdate:    []string{`"updaters"`},
+		NotifyExplicit: true,
+		MaxJournalSize: "10m",
+		CustomOptions:  []string{"zone-statistics full;", "check-names ignore;"},
+	}
+	if diff := deep.Equal(zone, expected); diff != nil {
+		t.Error(diff)
+	}
+
+	// secondary zones only get the default options as they cannot be updated
+	zone = templateZone{Name: "example.net", IsSecondary: true}
+	applyZoneOptions(&zone, primary, zoneOptionsTestACLs)
+	expected = templateZone{
+		Name:           "example.net",
+		IsSecondary:    true,
+		AllowQuery:     []string{`"trusted"`},
+		MaxJournalSize: "10m",
+		CustomOptions:  []string{"zone-statistics full;"},
+	}
+	if diff := deep.Equal(zone, expected); diff != nil {
+		t.Error(diff)
+	}
+}
@@ -0,0 +1,150 @@
+package hosts
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"regexp"
+	"text/template"
+	"time"
+
+	"peg.nu/nx/cache"
+	"peg.nu/nx/config"
+	"peg.nu/nx/model"
+	"peg.nu/nx/ns/dns"
+	"peg.nu/nx/util"
+)
+
+var logger = log.New(os.Stdout, "[hosts] ", log.LstdFlags)
+
+const (
+	// splitZone writes one hosts file per forward zone
+	splitZone = "zone"
+	// splitCombined writes a single hosts file with the addresses of all zones
+	splitCombined = "combined"
+)
+
+const combinedFileName = "hosts"
+
+type hostsEntry struct {
+	IP    string
+	Names []string
+}
+
+type templateVars struct {
+	Name        string
+	Entries     []hostsEntry
+	GeneratedAt string
+}
+
+func parseSplit(split string) string {
+	switch split {
+	case splitZone, splitCombined:
+		return split
+	case "":
+		return splitCombined
+	}
+
+	logger.Printf("Unknown hosts split <%s>, using %s", split, splitCombined)
+	return splitCombined
+}
+
+// addressNames returns the fully qualified name of the address and of every CNAME. With short names, the names relative
+// to the forward zone are added, which are only unique within a single zone.
+func addressNames(address dns.DNSIP, shortNames bool) []string {
+	zone := address.ForwardZoneName
+	names := []string{address.FQDN()}
+	if shortNames && address.FQDN() != zone {
+		names = append(names, address.IP.GetName())
+	}
+	for _, cname := range address.CNames {
+		names = append(names, fmt.Sprintf("%s.%s", cname, zone))
+		if shortNames {
+			names = append(names, cname)
+		}
+	}
+
+	return names
+}
+
+// buildHostsFiles groups the names of the addresses by IP, in one file per forward zone or in a single combined file
+func buildHostsFiles(addresses []dns.DNSIP, split string) map[string][]hostsEntry {
+	files := make(map[string][]hostsEntry)
+	for _, address := range addresses {
+		if len(address.ForwardZoneName) == 0 {
+			continue
+		}
+		ip, _, err := net.ParseCIDR(address.IP.Address)
+		if err != nil {
+			logger.Printf("Could not parse address %v: %v", address.IP.Address, err)
+			continue
+		}
+
+		file := combinedFileName
+		if split == splitZone {
+			file = address.ForwardZoneName
+		}
+
+		entries := files[file]
+		idx := len(entries)
+		for i, entry := range entries {
+			if entry.IP == ip.String() {
+				idx = i
+				break
+			}
+		}
+		if idx == len(entries) {
+			entries = append(entries, hostsEntry{IP: ip.String()})
+		}
+		for _, name := range addressNames(address, split == splitZone) {
+			if !util.SliceContainsString(entries[idx].Names, name) {
+				entries[idx].Names = append(entries[idx].Names, name)
+			}
+		}
+		files[file] = entries
+	}
+
+	return files
+}
+
+func hostsFileName(file string) string {
+	if file == combinedFileName {
+		return combinedFileName
+	}
+
+	return fmt.Sprintf("%s.hosts", file)
+}
+
+// GenerateHostsFiles writes the DNS enabled addresses in hosts file format, using the same names as the generated zones
+func GenerateHostsFiles(addresses []model.IPAddress, zones []dns.Zone, conf *config.NXConfig) {
+	hostsConf := conf.Namespaces.Hosts
+	if !hostsConf.Enabled {
+		return
+	}
+
+	templateString, err := os.ReadFile("templates/hosts.tmpl")
+	if err != nil {
+		panic(err)
+	}
+	hostsTemplate := template.Must(template.New("hosts").Parse(string(templateString)))
+	ignoreRegexes := []*regexp.Regexp{
+		regexp.MustCompile("(?m)^# Generated at .*$"),
+	}
+	cw := cache.New(hostsTemplate, ignoreRegexes, false)
+
+	vars := templateVars{GeneratedAt: time.Now().Format(time.RFC3339)}
+	files := buildHostsFiles(dns.NormalizedAddresses(addresses, zones, conf), parseSplit(hostsConf.Split))
+	for file, entries := range files {
+		vars.Name = file
+		vars.Entries = entries
+
+		_, err := cw.WriteTemplate(fmt.Sprintf("generated/hosts/%s", hostsFileName(file)), vars)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	util.CleanDirectoryExcept("generated/hosts", cw.ProcessedFiles, conf)
+	conf.UpdatedFiles = append(conf.UpdatedFiles, cw.UpdatedFiles...)
+}
@@ -0,0 +1,53 @@
+package hosts
+
+import (
+	"testing"
+
+	"github.com/go-test/deep"
+	"peg.nu/nx/model"
+	"peg.nu/nx/ns/dns"
+)
+
+func hostsTestAddresses() []dns.DNSIP {
+	return []dns.DNSIP{
+		{IP: &model.IPAddress{ID: 1, Address: "10.0.0.5/24", DnsName: "web"}, ForwardZoneName: "example.com", CNames: []string{"www"}},
+		{IP: &model.IPAddress{ID: 2, Address: "10.0.0.6/24", DnsName: "db"}, ForwardZoneName: "lab.example.com"},
+		{IP: &model.IPAddress{ID: 3, Address: "10.0.1.5/24", DnsName: "web"}, ForwardZoneName: "example.org"},
+		{IP: &model.IPAddress{ID: 4, Address: "10.0.1.6/24", DnsName: "@"}, ForwardZoneName: "example.org"},
+	}
+}
+
+func TestBuildHostsFilesPerZone(t *testing.T) {
+	expected := map[string][]hostsEntry{
+		"example.com": {
+			{IP: "10.0.0.5", Names: []string{"web.example.com", "web", "www.example.com", "www"}},
+		},
+		"lab.example.com": {
+			{IP: "10.0.0.6", Names: []string{"db.lab.example.com", "db"}},
+		},
+		"example.org": {
+			{IP: "10.0.1.5", Names: []string{"web.example.org", "web"}},
+			{IP: "10.0.1.6", Names: []string{"example.org"}},
+		},
+	}
+
+	if diff := deep.Equal(buildHostsFiles(hostsTestAddresses(), splitZone), expected); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestBuildHostsFilesCombined(t *testing.T) {
+	// short names are ambiguous across zones and only written to the files per zone
+	expected := map[string][]hostsEntry{
+		combinedFileName: {
+			{IP: "10.0.0.5", Names: []string{"web.example.com", "www.example.com"}},
+			{IP: "10.0.0.6", Names: []string{"db.lab.example.com"}},
+			{IP: "10.0.1.5", Names: []string{"web.example.org"}},
+			{IP: "10.0.1.6", Names: []string{"example.org"}},
+		},
+	}
+
+	if diff := deep.Equal(buildHostsFiles(hostsTestAddresses(), splitCombined), expected); diff != nil {
+		t.Error(diff)
+	}
+}